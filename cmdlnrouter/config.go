@@ -0,0 +1,359 @@
+package cmdlnrouter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// configFile holds the values of a config file by key, which is the
+// long option without the dashes.
+type configFile struct {
+	path    string
+	profile string // the profile the values are from, if any
+	values  map[string]fileValue
+}
+
+type fileValue struct {
+	vals []string
+	line int // 0 when not known, like for JSON
+}
+
+// from returns where the value of the key is from, as path:line.
+func (f *configFile) from(key string) string {
+	if line := f.values[key].line; line > 0 {
+		return fmt.Sprintf("%s:%d", f.path, line)
+	}
+	return f.path
+}
+
+// addLongNames adds the long options of the options struct to longs,
+// without the dashes, with true for the ones that are repeatable.
+func addLongNames(longs map[string]bool, optsIn interface{}) {
+	elm, err := structOf(optsIn)
+	if err != nil {
+		return
+	}
+	for i := 0; i < elm.NumField(); i++ {
+		tField := elm.Type().Field(i)
+		_, optLong, _ := parseCmdlnTag(tField.Tag.Get("cmdln"))
+		if optLong != "" {
+			longs[strings.TrimLeft(optLong, "-")] = repeatable(tField.Type)
+		}
+	}
+}
+
+// optNames returns the names of the built in option long, with the
+// short name of the field of the options struct that has the same long
+// name, if there is one, so the field and the option are the same.
+func optNames(long string, optsIn interface{}) []string {
+	names := []string{long}
+	elm, err := structOf(optsIn)
+	if err != nil {
+		return names
+	}
+	for i := 0; i < elm.NumField(); i++ {
+		optShort, optLong, _ := parseCmdlnTag(elm.Type().Field(i).Tag.Get("cmdln"))
+		if optLong == long && optShort != "" {
+			names = append(names, optShort)
+		}
+	}
+	return names
+}
+
+// optValue returns the value given to the option by any of its names,
+// and marks it as used.
+func optValue(argMap map[string][]M, args []string, used []bool, names ...string) (val string, err error) {
+	for _, argv := range occurrences(argMap, names...) {
+		markUsed(used, argv, true)
+		if val, err = parseCmdlnVal(argv, args); err != nil {
+			return "", &ConversionError{Option: argv.opt, Type: reflect.TypeOf(val), Err: err}
+		}
+	}
+	return val, nil
+}
+
+// activeProfile returns the profile given by the --profile option, by
+// any of its names, or else by the <prefix>_PROFILE environment
+// variable, or else the one set by Profile, in which case given is
+// false.
+func (r *Router) activeProfile(argMap map[string][]M, args []string, used []bool, names []string) (profile string, given bool, err error) {
+	profile, err = optValue(argMap, args, used, names...)
+	if profile != "" || err != nil {
+		return profile, true, err
+	}
+	if env := envName(reflect.StructField{}, "--profile", r.fallback(nil).envPrefix); env != "" {
+		if profile = os.Getenv(env); profile != "" {
+			return profile, true, nil
+		}
+	}
+	return r.profile, false, nil
+}
+
+// findConfig returns the first of $XDG_CONFIG_HOME/<name>, where
+// $XDG_CONFIG_HOME is ~/.config when it is not set, and the
+// $XDG_CONFIG_DIRS/<name>, where $XDG_CONFIG_DIRS is /etc/xdg when it is
+// not set, that exists.
+func findConfig(name string) string {
+	home := os.Getenv("XDG_CONFIG_HOME")
+	if home == "" {
+		if h, err := os.UserHomeDir(); err == nil {
+			home = filepath.Join(h, ".config")
+		}
+	}
+	dirs := os.Getenv("XDG_CONFIG_DIRS")
+	if dirs == "" {
+		dirs = "/etc/xdg"
+	}
+
+	for _, dir := range append([]string{home}, filepath.SplitList(dirs)...) {
+		if dir == "" {
+			continue
+		}
+		if path := filepath.Join(dir, name); fileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+func fileExists(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir()
+}
+
+// loadConfig reads the config file at the path, or the one found for the
+// name when there is no path. A file ending in .json is a JSON object,
+// and any other file has key = value lines. The values of the profile,
+// when there is one, replace the shared values of the file. A profile
+// that is not given, but is the default, is skipped when there is no
+// file or section for it. Keys that are not in longs, and keys set more
+// than once in a section for options that are not repeatable, are
+// returned as errors.
+func loadConfig(name, path, profile string, given bool, longs map[string]bool) (*configFile, ParseErrors) {
+	if path == "" {
+		if path = findConfig(name); path == "" {
+			if profile != "" && given {
+				return nil, ParseErrors{&FileError{Path: name, Err: fmt.Errorf("there is no config file for the profile %q", profile)}}
+			}
+			return nil, nil
+		}
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, ParseErrors{&FileError{Path: path, Err: err}}
+	}
+
+	sections := map[string]map[string]fileValue{"": {}}
+	var errs ParseErrors
+	add := func(section, key string, vals []string, line int) {
+		values, ok := sections[section]
+		if !ok {
+			values = make(map[string]fileValue)
+			sections[section] = values
+		}
+		if key == "" {
+			return // the start of the section
+		}
+
+		repeat, ok := longs[key]
+		v, seen := values[key]
+		switch {
+		case !ok:
+			errs = append(errs, &FileError{Path: path, Line: line, Key: key, Err: errors.New("there is no such option")})
+		case seen && !repeat:
+			errs = append(errs, &FileError{Path: path, Line: line, Key: key, Err: errors.New("the option is set more than once")})
+		case seen:
+			v.vals = append(v.vals, vals...)
+			values[key] = v
+		default:
+			values[key] = fileValue{vals: vals, line: line}
+		}
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = parseJSONConfig(b, add)
+	} else {
+		err = parseINIConfig(b, add)
+	}
+	if err != nil {
+		var ferr *FileError
+		if !errors.As(err, &ferr) {
+			ferr = &FileError{Err: err}
+		}
+		ferr.Path = path
+		errs = append(errs, ferr)
+	}
+
+	f := &configFile{path: path, values: sections[""]}
+	if profile != "" {
+		values, ok := sections[profile]
+		switch {
+		case !ok && given:
+			return f, append(errs, &FileError{Path: path, Err: fmt.Errorf("there is no profile %q", profile)})
+		case ok:
+			f.profile = profile
+		}
+		for key, v := range values {
+			f.values[key] = v
+		}
+	}
+	return f, errs
+}
+
+// parseINIConfig reads key = value lines, where a value can be in quotes.
+// The lines after a [name] line are in the section of the profile name,
+// and the lines before the first one are shared. Blank lines and lines
+// starting with # or ; are skipped.
+func parseINIConfig(b []byte, add func(section, key string, vals []string, line int)) error {
+	var section string
+	for i, ln := range strings.Split(string(b), "\n") {
+		ln = strings.TrimSpace(ln)
+		if ln == "" || ln[0] == '#' || ln[0] == ';' {
+			continue
+		}
+		if ln[0] == '[' && ln[len(ln)-1] == ']' {
+			if section = strings.TrimSpace(ln[1 : len(ln)-1]); section == "" {
+				return &FileError{Line: i + 1, Err: errors.New("the section has no name")}
+			}
+			add(section, "", nil, i+1)
+			continue
+		}
+		key, val, ok := strings.Cut(ln, "=")
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if !ok || key == "" {
+			return &FileError{Line: i + 1, Err: fmt.Errorf("%q is not a key = value line", ln)}
+		}
+		if len(val) > 1 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		}
+		add(section, key, []string{val}, i+1)
+	}
+	return nil
+}
+
+// parseJSONConfig reads a JSON object. Strings, numbers and booleans are
+// single values, arrays of them are repeated values and objects of them
+// are key=value pairs, for map options. A null is skipped. The key
+// "profiles" holds an object of the profiles by name, each an object
+// like the shared one.
+func parseJSONConfig(b []byte, add func(section, key string, vals []string, line int)) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return errors.New("the file is not a JSON object")
+	}
+	return jsonObject(dec, "", add)
+}
+
+// jsonObject reads the keys of an object, after its opening brace, into
+// the section.
+func jsonObject(dec *json.Decoder, section string, add func(section, key string, vals []string, line int)) error {
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+
+		if section == "" && key == "profiles" {
+			if err := jsonProfiles(dec, add); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return err
+		}
+		vals, err := jsonValues(v)
+		if err != nil {
+			return &FileError{Key: key, Err: err}
+		}
+		if vals != nil {
+			add(section, key, vals, 0)
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+func jsonProfiles(dec *json.Decoder, add func(section, key string, vals []string, line int)) error {
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return &FileError{Key: "profiles", Err: errors.New("the profiles are not a JSON object")}
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name := tok.(string)
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('{') || name == "" {
+			return &FileError{Key: "profiles." + name, Err: errors.New("the profile is not a named JSON object")}
+		}
+		add(name, "", nil, 0)
+		if err := jsonObject(dec, name, add); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+func jsonValues(v interface{}) ([]string, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		vals := make([]string, 0, len(x))
+		for _, e := range x {
+			s, err := jsonScalar(e)
+			if err != nil {
+				return nil, err
+			}
+			vals = append(vals, s)
+		}
+		return vals, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		vals := make([]string, 0, len(x))
+		for _, k := range keys {
+			s, err := jsonScalar(x[k])
+			if err != nil {
+				return nil, err
+			}
+			vals = append(vals, k+"="+s)
+		}
+		return vals, nil
+	}
+	s, err := jsonScalar(v)
+	if err != nil {
+		return nil, err
+	}
+	return []string{s}, nil
+}
+
+func jsonScalar(v interface{}) (string, error) {
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case json.Number:
+		return x.String(), nil
+	case bool:
+		return fmt.Sprint(x), nil
+	}
+	return "", fmt.Errorf("the value %v is not a string, number or boolean", v)
+}
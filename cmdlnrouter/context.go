@@ -28,7 +28,12 @@ type Context struct {
 
 	bag        map[string]interface{} // holds items to pass along with the context
 	cmdlnAsRaw []byte                 // The full raw commandline as bytes.
-	cmdlnParse []byte                 // The full parsed commandline as bytes.
+	rawArgs    []string               // The raw commandline arguments.
+	args       []Argument             // The parsed positional arguments.
+	rest       []Argument             // The positional arguments after the route.
+	sources    []Source               // Where the value of each option is from.
+	debug      bool                   // The debug flag was given.
+	profile    string                 // The profile of the config file.
 }
 
 func (c *Context) Set(key string, value interface{}) {
@@ -42,12 +47,28 @@ func (c *Context) Get(key string) interface{} {
 	return c.bag[key]
 }
 
+// Args returns the positional arguments after the fields of the command
+// line, for a route given an Arity.
+func (c *Context) Args() []string {
+	args := make([]string, 0, len(c.rest))
+	for _, a := range c.rest {
+		args = append(args, a.arg)
+	}
+	return args
+}
+
+// Profile returns the profile of the config file the options are from,
+// or "" when there is none.
+func (c *Context) Profile() string {
+	return c.profile
+}
+
 // NewContext returns a new context that can be used by the commandline
 // options
 func NewContext() *Context {
 	c := new(Context)
 	c.bag = make(map[string]interface{})
-	
+
 	c.Stdin = os.Stdin
 	c.Stdout = os.Stdout
 	c.StdErr = os.Stderr
@@ -55,6 +76,14 @@ func NewContext() *Context {
 	return c
 }
 
+// Exit stops the handler, like os.Exit, but unwinds through the router
+// so deferred calls and the HandlerDone handler still run. Parse exits
+// the process with the code afterwards, and Run returns it as an
+// ExitError when it is not 0.
+func (c *Context) Exit(code int) {
+	panic(&ExitError{Code: code})
+}
+
 // Ask is a convenience method for getting commandline input.
 func (c *Context) Ask(s string) (r string) {
 	fmt.Fprint(c.Stdout, s, " ")
@@ -76,6 +105,4 @@ func (c *Context) Confirm(s string) (r bool, e error) {
 	default:
 		return false, errors.New("Invalid Response: " + scnln.Text())
 	}
-
-	return false, errors.New("Should not get here.")
 }
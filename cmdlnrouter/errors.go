@@ -0,0 +1,226 @@
+package cmdlnrouter
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// NotFoundError is returned when the commandline does not resolve to
+// a handler and there is no NotFoundHandler.
+type NotFoundError struct {
+	Args []string // The positional arguments of the commandline.
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("command not found: %q", strings.Join(e.Args, " "))
+}
+
+// UnhandledError is returned when the commandline has options that no
+// options struct has, the router is in the ParseFailOnUnhandled mode and
+// there is no UnhandledHandler.
+type UnhandledError struct {
+	Options map[string]string // The unhandled options and their values.
+}
+
+func (e *UnhandledError) Error() string {
+	opts := make([]string, 0, len(e.Options))
+	for k := range e.Options {
+		opts = append(opts, k)
+	}
+	sort.Strings(opts)
+	return "unhandled options: " + strings.Join(opts, ", ")
+}
+
+// AmbiguousError is returned, in the ParseOptAbbrev mode, when an
+// abbreviated option or command could be more than one of them.
+type AmbiguousError struct {
+	Arg        string   // The abbreviation as found on the commandline.
+	Candidates []string // What it could be.
+}
+
+func (e *AmbiguousError) Error() string {
+	return fmt.Sprintf("%q is ambiguous, it could be: %s", e.Arg, strings.Join(e.Candidates, ", "))
+}
+
+// MissingError is returned before the handler runs, when required
+// options are not set or the command line has a number of positional
+// arguments out of the range given to Router.Arity. It lists everything
+// that is missing at once.
+type MissingError struct {
+	Options   []string // The required options that are not set.
+	Arguments string   // What is wrong with the number of arguments, if anything.
+	Usage     string   // The usage line from Router.Help.
+}
+
+func (e *MissingError) Error() string {
+	var issues []string
+	if len(e.Options) > 0 {
+		issues = append(issues, "missing required options: "+strings.Join(e.Options, ", "))
+	}
+	if e.Arguments != "" {
+		issues = append(issues, e.Arguments)
+	}
+	return strings.Join(issues, "; ") + "\n" + e.Usage
+}
+
+// ConversionError is returned when the value of an option can not be
+// converted to the type of its field.
+type ConversionError struct {
+	Option string       // The option as found on the commandline.
+	Value  string       // The raw value.
+	Type   reflect.Type // The type of the field.
+	Err    error
+}
+
+func (e *ConversionError) Error() string {
+	return fmt.Sprintf("invalid value %q for option %s (%s): %v", e.Value, e.Option, e.Type, e.Err)
+}
+
+func (e *ConversionError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError is returned when the value of an option breaks one
+// of the rules in the tags of its field: choices, min, max or pattern.
+type ValidationError struct {
+	Option string // The option as found on the commandline.
+	Value  string // The raw value, or the part of it that breaks the rule.
+	Rule   string // The name of the tag.
+	Limit  string // The value of the tag.
+}
+
+func (e *ValidationError) Error() string {
+	var must string
+	switch e.Rule {
+	case "choices":
+		must = "be one of " + strings.Join(strings.Split(e.Limit, ","), ", ")
+	case "min":
+		must = "be at least " + e.Limit
+	case "max":
+		must = "be at most " + e.Limit
+	case "pattern":
+		must = "match " + e.Limit
+	}
+	return fmt.Sprintf("invalid value %q for option %s: it must %s", e.Value, e.Option, must)
+}
+
+// GroupError is returned when the options given break a relation
+// between them, from the exclusive, requires or conflicts tags of their
+// fields. An atleastone group that is not set is in a MissingError.
+type GroupError struct {
+	Rule    string   // The name of the tag.
+	Options []string // The options, with the one that has the tag first for requires and conflicts.
+}
+
+func (e *GroupError) Error() string {
+	opts := strings.Join(e.Options, ", ")
+	switch e.Rule {
+	case "exclusive":
+		return "only one of these options can be given: " + opts
+	case "requires":
+		return fmt.Sprintf("the option %s needs: %s", e.Options[0], strings.Join(e.Options[1:], ", "))
+	}
+	return fmt.Sprintf("the option %s can not be given with: %s", e.Options[0], strings.Join(e.Options[1:], ", "))
+}
+
+// FileError is returned when the config file can not be read, or has a
+// key that is not an option or is set more than once.
+type FileError struct {
+	Path string
+	Line int    // The line, when it is known.
+	Key  string // The key, if the issue is with one.
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	at := e.Path
+	if e.Line > 0 {
+		at = fmt.Sprintf("%s:%d", e.Path, e.Line)
+	}
+	if e.Key != "" {
+		return fmt.Sprintf("%s: %s: %v", at, e.Key, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", at, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigError is returned when the struct given to Router.Options or
+// Router.Command can not be used, either as a whole or for one field.
+type ConfigError struct {
+	Type  reflect.Type // The type given.
+	Field string       // The name of the field, if the issue is with one.
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s field %s: %v", e.Type, e.Field, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Type, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ParseErrors holds every error found while parsing the commandline, so
+// they can all be shown at once. Use errors.As to find a single one.
+type ParseErrors []error
+
+func (e ParseErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e ParseErrors) Unwrap() []error {
+	return e
+}
+
+// ExitError is returned by Run when a handler calls Context.Exit with a
+// code other than 0.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (e *ExitError) err() error {
+	if e.Code == 0 {
+		return nil
+	}
+	return e
+}
+
+// PanicError is returned by Run when a handler panics and the panic is
+// recovered by the PanicHandler.
+type PanicError struct {
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// structOf returns the struct the value points to, or a ConfigError if
+// it is not a pointer to a struct.
+func structOf(i interface{}) (reflect.Value, error) {
+	val := reflect.ValueOf(i)
+	if !val.IsValid() {
+		return val, &ConfigError{Err: errors.New("there are no options, only a pointer to a struct can be used")}
+	}
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return val, &ConfigError{Type: val.Type(), Err: errors.New("only a pointer to a struct can be used")}
+	}
+	return val.Elem(), nil
+}
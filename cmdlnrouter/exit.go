@@ -0,0 +1,65 @@
+package cmdlnrouter
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// osExit and stderr are swapped out when testing Parse.
+var (
+	osExit           = os.Exit
+	stderr io.Writer = os.Stderr
+)
+
+// ExitCodes maps the errors returned by Run to the exit code of the
+// process.
+type ExitCodes struct {
+	NotFound  int // NotFoundError
+	Unhandled int // UnhandledError
+	BadValue  int // ParseErrors, ConversionError, ValidationError, GroupError, FileError, ConfigError, AmbiguousError and MissingError
+	Panic     int // PanicError
+	Handler   int // Any other error returned by a handler
+}
+
+// DefaultExitCodes are the exit codes used when the router has none.
+var DefaultExitCodes = ExitCodes{
+	NotFound:  2,
+	Unhandled: 2,
+	BadValue:  2,
+	Panic:     70,
+	Handler:   1,
+}
+
+// Code returns the exit code for the error, which is 0 for nil and the
+// code given to Context.Exit for an ExitError.
+func (e *ExitCodes) Code(err error) int {
+	var exit *ExitError
+	var notFound *NotFoundError
+	var unhandled *UnhandledError
+	var parse ParseErrors
+	var conversion *ConversionError
+	var validation *ValidationError
+	var group *GroupError
+	var config *ConfigError
+	var file *FileError
+	var ambiguous *AmbiguousError
+	var missing *MissingError
+	var panicked *PanicError
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exit):
+		return exit.Code
+	case errors.As(err, &notFound):
+		return e.NotFound
+	case errors.As(err, &unhandled):
+		return e.Unhandled
+	case errors.As(err, &parse), errors.As(err, &conversion), errors.As(err, &validation), errors.As(err, &group), errors.As(err, &config), errors.As(err, &file), errors.As(err, &ambiguous), errors.As(err, &missing):
+		return e.BadValue
+	case errors.As(err, &panicked):
+		return e.Panic
+	}
+	return e.Handler
+}
@@ -0,0 +1,59 @@
+package cmdlnrouter
+
+import (
+	"os"
+	"reflect"
+	"strings"
+)
+
+// fallback holds where an option that is not on the commandline is
+// looked for, before its default is used: the environment and then the
+// config file.
+type fallback struct {
+	envPrefix string
+	file      *configFile
+}
+
+// fallback returns where the options of the router fall back to.
+func (r *Router) fallback(file *configFile) (fb fallback) {
+	if x := r.up(nil, func(x *Router) bool { return x.envPrefix != "" }); x != nil {
+		fb.envPrefix = x.envPrefix
+	}
+	fb.file = file
+	return fb
+}
+
+// lookup returns the values of the option of the field from outside the
+// commandline, where they are from, SourceEnv or SourceFile, and the
+// name of that, like $APP_TOKEN or a path:line. An environment variable
+// that is set but empty is taken as not set.
+func (fb fallback) lookup(tField reflect.StructField, optLong string) (vals []string, from, name string, ok bool) {
+	if env := envName(tField, optLong, fb.envPrefix); env != "" {
+		if val := os.Getenv(env); val != "" {
+			return []string{val}, SourceEnv, "$" + env, true
+		}
+	}
+	if key := strings.TrimLeft(optLong, "-"); fb.file != nil && key != "" {
+		if v, ok := fb.file.values[key]; ok {
+			return v.vals, SourceFile, fb.file.from(key), true
+		}
+	}
+	return nil, "", "", false
+}
+
+// envName returns the environment variable the option of the field falls
+// back to. It is from the env tag, where - means none, or else from the
+// prefix and the long option.
+func envName(tField reflect.StructField, optLong, prefix string) string {
+	if name, ok := tField.Tag.Lookup("env"); ok {
+		if name == "-" {
+			return ""
+		}
+		return name
+	}
+	if prefix == "" || optLong == "" {
+		return ""
+	}
+	name := strings.ReplaceAll(strings.TrimLeft(optLong, "-"), "-", "_")
+	return strings.ToUpper(strings.TrimSuffix(prefix, "_") + "_" + name)
+}
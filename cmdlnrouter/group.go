@@ -0,0 +1,121 @@
+package cmdlnrouter
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// groupRule is a relation between options, from the exclusive,
+// atleastone, requires or conflicts tags of the options struct.
+type groupRule struct {
+	rule string
+	opt  string   // The option with the tag, for requires and conflicts.
+	opts []string // The options of the group, or that opt relates to.
+}
+
+func (g groupRule) String() string {
+	opts := strings.Join(g.opts, ", ")
+	switch g.rule {
+	case "exclusive":
+		return "only one of: " + opts
+	case "atleastone":
+		return "at least one of: " + opts
+	case "requires":
+		return g.opt + " requires: " + opts
+	}
+	return g.opt + " conflicts with: " + opts
+}
+
+// optName returns the name an option is shown by, which is the long one
+// when there is one.
+func optName(optShort, optLong string) string {
+	if optLong != "" {
+		return optLong
+	}
+	return optShort
+}
+
+// groupRules returns the relations between the options of the struct,
+// in the order of the fields. Options that requires and conflicts name,
+// by any of their names, that are not in the struct are returned as
+// errors.
+func groupRules(elm reflect.Value) (rules []groupRule, errs ParseErrors) {
+	names := make(map[string]string)
+	for i := 0; i < elm.NumField(); i++ {
+		optShort, optLong, _ := parseCmdlnTag(elm.Type().Field(i).Tag.Get("cmdln"))
+		for _, v := range []string{optShort, optLong} {
+			if v != "" {
+				names[v] = optName(optShort, optLong)
+			}
+		}
+	}
+
+	groups := make(map[string]int)
+	for i := 0; i < elm.NumField(); i++ {
+		tField := elm.Type().Field(i)
+		tags := tField.Tag.Get("cmdln")
+		if tags == "" {
+			continue
+		}
+		optShort, optLong, _ := parseCmdlnTag(tags)
+		name := optName(optShort, optLong)
+
+		for _, rule := range []string{"exclusive", "atleastone"} {
+			if group, ok := tField.Tag.Lookup(rule); ok {
+				j, ok := groups[rule+":"+group]
+				if !ok {
+					j = len(rules)
+					groups[rule+":"+group] = j
+					rules = append(rules, groupRule{rule: rule})
+				}
+				rules[j].opts = append(rules[j].opts, name)
+			}
+		}
+
+		for _, rule := range []string{"requires", "conflicts"} {
+			others, ok := tField.Tag.Lookup(rule)
+			if !ok {
+				continue
+			}
+			g := groupRule{rule: rule, opt: name}
+			for _, other := range strings.Split(others, ",") {
+				if names[other] == "" {
+					errs = append(errs, &ConfigError{Type: elm.Type(), Field: tField.Name, Err: fmt.Errorf("the %s tag has an unknown option %q", rule, other)})
+					continue
+				}
+				g.opts = append(g.opts, names[other])
+			}
+			rules = append(rules, g)
+		}
+	}
+	return rules, errs
+}
+
+// checkGroups returns the relations between the options of the struct
+// that the given options break. An atleastone group is checked with the
+// required options instead, before the handler runs, so it only applies
+// to the commands of the router.
+func checkGroups(elm reflect.Value, given map[string]bool) ParseErrors {
+	rules, errs := groupRules(elm)
+	for _, g := range rules {
+		var in, out []string
+		for _, opt := range g.opts {
+			if given[opt] {
+				in = append(in, opt)
+			} else {
+				out = append(out, opt)
+			}
+		}
+
+		switch {
+		case g.rule == "exclusive" && len(in) > 1:
+			errs = append(errs, &GroupError{Rule: g.rule, Options: in})
+		case g.rule == "requires" && given[g.opt] && len(out) > 0:
+			errs = append(errs, &GroupError{Rule: g.rule, Options: append([]string{g.opt}, out...)})
+		case g.rule == "conflicts" && given[g.opt] && len(in) > 0:
+			errs = append(errs, &GroupError{Rule: g.rule, Options: append([]string{g.opt}, in...)})
+		}
+	}
+	return errs
+}
@@ -43,8 +43,8 @@ func (s ByHelpFields) Less(i, j int) bool {
 	sj := (strings.Trim(s[j].ShortFld, "-") + " ")[0]
 	if s[i].ShortFld == "" && s[j].ShortFld == "" {
 		// Case doesn't matter for long strings
-		si = (strings.Trim(strings.ToLower(s[i].LongFld), "-") + " ")[0]
-		sj = (strings.Trim(strings.ToLower(s[j].LongFld), "-") + " ")[0]
+		si = (strings.TrimPrefix(strings.Trim(strings.ToLower(s[i].LongFld), "-"), "[no-]") + " ")[0]
+		sj = (strings.TrimPrefix(strings.Trim(strings.ToLower(s[j].LongFld), "-"), "[no-]") + " ")[0]
 	}
 	return helpFieldSortOrder[si] < helpFieldSortOrder[sj]
 }
@@ -77,6 +77,25 @@ func parseCmdlnVTag(tag, fieldName string) (optVar string) {
 	return
 }
 
+// format returns the format of the values of type t, for the types
+// where it is not obvious.
+func format(t reflect.Type, tag reflect.StructTag) string {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return layout(tag)
+	case durationType:
+		return "1h30m"
+	case fileModeType:
+		return "0644"
+	case reflect.TypeOf(ByteSize(0)):
+		return "512MiB or 10GB"
+	}
+	return ""
+}
+
 func maxOptLen(oldOptLen, newOptLen int) (optLen int) {
 	optLen = newOptLen
 	if newOptLen < oldOptLen {
@@ -85,7 +104,7 @@ func maxOptLen(oldOptLen, newOptLen int) (optLen int) {
 	return
 }
 
-func (r *Router) helpMap() (helpFlags, helpOptions []helpFields) {
+func (r *Router) helpMap(profile string) (helpFlags, helpOptions []helpFields) {
 
 	// Loop through all and convert to the first part of the line
 	// Find the longsest first part then 3 extra spaces
@@ -96,6 +115,8 @@ func (r *Router) helpMap() (helpFlags, helpOptions []helpFields) {
 
 		val := reflect.ValueOf(reflect.ValueOf(r.opts).Interface())
 		elm := val.Elem()
+		fb := r.fallback(nil)
+		var hasConfig, hasProfile bool
 
 		// Loop through the fields of the options struct
 		// to get the short & long names, descriptions and
@@ -107,14 +128,44 @@ func (r *Router) helpMap() (helpFlags, helpOptions []helpFields) {
 			var optVar string
 			optShort, optLong, optDesc := parseCmdlnTag(tField.Tag.Get("cmdln"))
 
+			if f := format(vField.Type(), tField.Tag); f != "" {
+				optDesc = strings.TrimSpace(optDesc + " (format: " + f + ")")
+			}
+			if env := envName(tField, optLong, fb.envPrefix); env != "" {
+				optDesc = strings.TrimSpace(optDesc + " (env: " + env + ")")
+			}
+			if def, ok := tField.Tag.Lookup("default"); ok {
+				optDesc = strings.TrimSpace(optDesc + " (default: " + def + ")")
+			}
+			if c, ok := tField.Tag.Lookup("choices"); ok {
+				optDesc = strings.TrimSpace(optDesc + " (choices: " + strings.Join(strings.Split(c, ","), ", ") + ")")
+			}
+			if tField.Tag.Get("required") == "true" {
+				optDesc = strings.TrimSpace(optDesc + " (required)")
+			}
+
 			if fmt.Sprintf("%s", vField.Type()) == "*bool" {
+				if optLong != "" {
+					optLong = "--[no-]" + strings.TrimLeft(optLong, "-")
+				}
+				helpFlags = append(helpFlags, helpFields{
+					ShortFld: optShort,
+					LongFld:  optLong,
+					DescFld:  optDesc,
+				})
+			} else if counter(tField) {
+				optVar = "..." // repeatable
 				helpFlags = append(helpFlags, helpFields{
 					ShortFld: optShort,
 					LongFld:  optLong,
+					VarFld:   optVar,
 					DescFld:  optDesc,
 				})
 			} else {
 				optVar = parseCmdlnVTag(tField.Tag.Get("cmdvar"), tField.Name)
+				if repeatable(vField.Type()) {
+					optVar += "..." // repeatable
+				}
 				helpOptions = append(helpOptions, helpFields{
 					ShortFld: optShort,
 					LongFld:  optLong,
@@ -124,6 +175,43 @@ func (r *Router) helpMap() (helpFlags, helpOptions []helpFields) {
 			}
 
 			optLen = maxOptLen(optLen, len(optShort)+len(optLong)+len(optVar))
+			hasConfig = hasConfig || optLong == "--config"
+			hasProfile = hasProfile || optLong == "--profile"
+		}
+
+		if r.config != "" && !hasConfig {
+			helpOptions = append(helpOptions, helpFields{
+				LongFld: "--config",
+				VarFld:  "path",
+				DescFld: "The config file (default: the first " + r.config + " in the XDG config directories)",
+			})
+			optLen = maxOptLen(optLen, len("--config")+len("path"))
+		}
+		if r.config != "" && !hasProfile {
+			desc := "The profile of the config file"
+			if env := envName(reflect.StructField{}, "--profile", r.fallback(nil).envPrefix); env != "" {
+				desc += " (env: " + env + ")"
+			}
+			if r.profile != "" {
+				desc += " (default: " + r.profile + ")"
+			}
+			if profile != "" {
+				desc += " (active: " + profile + ")"
+			}
+			helpOptions = append(helpOptions, helpFields{
+				LongFld: "--profile",
+				VarFld:  "name",
+				DescFld: desc,
+			})
+			optLen = maxOptLen(optLen, len("--profile")+len("name"))
+		}
+
+		if r.debug != "" {
+			helpFlags = append(helpFlags, helpFields{
+				LongFld: r.debug,
+				DescFld: "Show the options and where their values are from",
+			})
+			optLen = maxOptLen(optLen, len(r.debug))
 		}
 
 		for _, fields := range [][]helpFields{helpFlags, helpOptions} {
@@ -158,8 +246,8 @@ func genCmdTxt(helpTree []map[string][]int) (cmdLnTxt string) {
 				cmdTxt = "[ " + strings.ToUpper(cmdTxt) + " ]"
 			}
 
-			if strings.Contains(cmdTxt, `\|`) {
-				cmdTxt = "[ " + strings.Join(strings.Split(cmdTxt, `\|`), " | ") + " ]"
+			if strings.Contains(cmdTxt, "|") {
+				cmdTxt = "[ " + strings.Join(strings.Split(cmdTxt, "|"), " | ") + " ]"
 			}
 
 			cmdTxts = append(cmdTxts, cmdTxt)
@@ -176,9 +264,19 @@ func genCmdTxt(helpTree []map[string][]int) (cmdLnTxt string) {
 }
 
 func (r *Router) Help() string {
+	return r.help("")
+}
+
+// HelpFor is Help for the commandline of the context, so it also shows
+// the active profile of the config file.
+func (r *Router) HelpFor(c *Context) string {
+	return r.help(c.profile)
+}
+
+func (r *Router) help(profile string) string {
 	out := new(bytes.Buffer)
 
-	helpFlags, helpOptions := r.helpMap()
+	helpFlags, helpOptions := r.helpMap(profile)
 
 	appTxt := filepath.Base(os.Args[0])
 	flgTxt := genFlgTxt(helpFlags)
@@ -191,6 +289,7 @@ func (r *Router) Help() string {
 		Command      string
 		FlagsRange   []helpFields
 		OptionsRange []helpFields
+		Groups       []string
 	}{
 		Application:  appTxt,
 		ShortFlags:   flgTxt,
@@ -199,6 +298,15 @@ func (r *Router) Help() string {
 		OptionsRange: helpOptions,
 	}
 
+	if r.opts != nil {
+		if elm, err := structOf(r.opts); err == nil {
+			rules, _ := groupRules(elm)
+			for _, g := range rules {
+				hlpTplData.Groups = append(hlpTplData.Groups, g.String())
+			}
+		}
+	}
+
 	t := template.Must(template.New("help").Parse(helpBasic))
 	err := t.Execute(out, hlpTplData)
 	if err != nil {
@@ -212,6 +320,30 @@ func (r *Router) CmdList() []string {
 	return r.cmdlst
 }
 
+// Choices returns the values the option can take, from the choices tag
+// of its field in the options of the router or its subrouters, so they
+// can be offered by shell completion. It is nil when there are none.
+func (r *Router) Choices(option string) (choices []string) {
+	r.walk(func(x *Router) {
+		if x.opts == nil || choices != nil {
+			return
+		}
+		elm, err := structOf(x.opts)
+		if err != nil {
+			return
+		}
+		for i := 0; i < elm.NumField(); i++ {
+			tField := elm.Type().Field(i)
+			optShort, optLong, _ := parseCmdlnTag(tField.Tag.Get("cmdln"))
+			if c, ok := tField.Tag.Lookup("choices"); ok && option != "" && (option == optShort || option == optLong) {
+				choices = strings.Split(c, ",")
+				return
+			}
+		}
+	})
+	return choices
+}
+
 var (
 	helpShort = `{{.Application}}, version {{.Version}}
 
@@ -239,6 +371,9 @@ Options:{{range $i, $v := .OptionsRange}}
   {{with $v.ShortFld}}{{.}}{{end}}{{with $v.LongFld}}{{if $v.ShortFld}}, {{end}}{{.}}{{end}}{{with $v.VarFld}} {{.}}{{end}}{{$v.PadSpace}}{{if $v.ShortFld | not}}  {{end}}{{if $v.LongFld | not}}  {{end}}{{if $v.VarFld | not}} {{end}}   {{$v.DescFld}}{{end}}
 
 Flags:{{range $k, $v := .FlagsRange}}
-  {{with $v.ShortFld}}{{.}}{{end}}{{with $v.LongFld}}{{if $v.ShortFld}}, {{end}}{{.}}{{end}}{{with $v.VarFld}} {{.}}{{end}}{{$v.PadSpace}}{{if $v.ShortFld | not}}  {{end}}{{if $v.LongFld | not}}  {{end}}{{if $v.VarFld | not}} {{end}}   {{$v.DescFld}}{{end}}
-`
+  {{with $v.ShortFld}}{{.}}{{end}}{{with $v.LongFld}}{{if $v.ShortFld}}, {{end}}{{.}}{{end}}{{with $v.VarFld}}{{.}} {{end}}{{$v.PadSpace}}{{if $v.ShortFld | not}}  {{end}}{{if $v.LongFld | not}}  {{end}}{{if $v.VarFld | not}} {{end}}   {{$v.DescFld}}{{end}}
+{{with .Groups}}
+Groups:{{range .}}
+  {{.}}{{end}}
+{{end}}`
 )
@@ -0,0 +1,139 @@
+package cmdlnrouter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// expandArgs returns the arguments with each @path replaced by the
+// arguments in the response file at the path, and each @@ at the start
+// of an argument replaced by @.
+func expandArgs(args []string) (out []string, err error) {
+	for _, arg := range args {
+		if out, err = expandArg(out, arg, "", nil); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// expandArg appends the argument to out, or the arguments of the response
+// file it names, which can name response files too. A relative path in a
+// response file is from the directory of that file. The stack holds the
+// files being read, to find ones that include themselves.
+func expandArg(out []string, arg, dir string, stack []string) ([]string, error) {
+	switch {
+	case strings.HasPrefix(arg, "@@"):
+		return append(out, arg[1:]), nil
+	case len(arg) < 2 || arg[0] != '@':
+		return append(out, arg), nil
+	}
+
+	path := arg[1:]
+	if dir != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, &FileError{Path: path, Err: err}
+	}
+	for _, p := range stack {
+		if p == abs {
+			return nil, &FileError{Path: path, Err: errors.New("the response file includes itself")}
+		}
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &FileError{Path: path, Err: err}
+	}
+	words, err := splitWords(string(b))
+	if err != nil {
+		var ferr *FileError
+		if errors.As(err, &ferr) {
+			ferr.Path = path
+		}
+		return nil, err
+	}
+
+	stack = append(stack[:len(stack):len(stack)], abs)
+	for _, w := range words {
+		if out, err = expandArg(out, w, filepath.Dir(path), stack); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// splitWords splits the text of a response file into arguments, like a
+// shell does. They are separated by white space, including new lines, so
+// a line with no spaces or quotes is one argument. Text in ' quotes is
+// as is, text in " quotes can have \", \\, \$ and \` escapes, and outside
+// of quotes a \ escapes any character. A # at the start of an argument
+// comments out the rest of the line.
+func splitWords(s string) (words []string, err error) {
+	var w strings.Builder
+	var inWord bool
+	var quote rune
+	line, start := 1, 1
+
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				w.WriteRune(r)
+			}
+		case quote == '"':
+			switch {
+			case r == '"':
+				quote = 0
+			case r == '\\' && i+1 < len(rs) && strings.ContainsRune("\"\\$`\n", rs[i+1]):
+				if i++; rs[i] != '\n' {
+					w.WriteRune(rs[i])
+				}
+			default:
+				w.WriteRune(r)
+			}
+		case r == '\\':
+			if i+1 < len(rs) {
+				if i++; rs[i] != '\n' {
+					w.WriteRune(rs[i])
+				}
+			}
+			inWord = true
+		case r == '\'' || r == '"':
+			quote, inWord, start = r, true, line
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, w.String())
+				w.Reset()
+				inWord = false
+			}
+		case r == '#' && !inWord:
+			for i+1 < len(rs) && rs[i+1] != '\n' {
+				i++
+			}
+		default:
+			w.WriteRune(r)
+			inWord = true
+		}
+		if rs[i] == '\n' {
+			line++
+		}
+	}
+
+	if quote != 0 {
+		return nil, &FileError{Line: start, Err: errors.New("the quote is not closed")}
+	}
+	if inWord {
+		words = append(words, w.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,265 @@
+package cmdlnrouter
+
+import (
+	"sort"
+	"strings"
+)
+
+// Route segment kinds, ordered from the least to the most specific.
+const (
+	segOptParam = iota
+	segParam
+	segAlt
+	segLiteral
+)
+
+// segment is a single space separated field of a registered command
+// line. A literal holds one word, an alternation (a|b) holds each of
+// the words and a :param or :optional: param holds the name.
+type segment struct {
+	fld  string
+	kind int
+	lits []string
+	name string
+}
+
+func parseSegment(fld string) (seg segment) {
+	seg.fld = fld
+	switch {
+	case len(fld) > 2 && fld[0] == ':' && fld[len(fld)-1] == ':':
+		seg.kind, seg.name = segOptParam, fld[1:len(fld)-1]
+	case len(fld) > 1 && fld[0] == ':':
+		seg.kind, seg.name = segParam, fld[1:]
+	case strings.Contains(fld, "|"):
+		seg.kind, seg.lits = segAlt, strings.Split(fld, "|")
+	default:
+		seg.kind, seg.lits = segLiteral, []string{fld}
+	}
+	return
+}
+
+func (seg segment) has(arg string) bool {
+	for _, lit := range seg.lits {
+		if lit == arg {
+			return true
+		}
+	}
+	return false
+}
+
+// route is a single registered command line and the router it was
+// registered on.
+type route struct {
+	cmdln  string
+	segs   []segment
+	handle HandleE
+	router *Router
+
+	rest     bool // takes positional arguments after its fields
+	min, max int  // how many, with -1 as no max
+}
+
+func newRoute(cmdln string, handle HandleE) *route {
+	rt := &route{cmdln: cmdln, handle: handle}
+	for _, fld := range strings.Fields(cmdln) {
+		rt.segs = append(rt.segs, parseSegment(fld))
+	}
+	return rt
+}
+
+// node is a single level of the command tree. Literal children are
+// found with a map lookup, the other children are tried in order of
+// their specificity: alternations, params and then optional params.
+type node struct {
+	lits   map[string]*node
+	alts   []*node
+	params []*node
+	opts   []*node
+
+	seg   segment // the segment leading to this node
+	route *route  // set when a command line ends at this node
+}
+
+func (n *node) child(seg segment) *node {
+	if seg.kind == segLiteral {
+		if n.lits == nil {
+			n.lits = make(map[string]*node)
+		}
+		if n.lits[seg.fld] == nil {
+			n.lits[seg.fld] = &node{seg: seg}
+		}
+		return n.lits[seg.fld]
+	}
+
+	children := &n.alts
+	switch seg.kind {
+	case segParam:
+		children = &n.params
+	case segOptParam:
+		children = &n.opts
+	}
+	for _, c := range *children {
+		if c.seg.fld == seg.fld {
+			return c
+		}
+	}
+	c := &node{seg: seg}
+	*children = append(*children, c)
+	return c
+}
+
+// insert adds the route to the tree. Registering the same command line
+// again replaces the earlier route.
+func (n *node) insert(rt *route) {
+	for _, seg := range rt.segs {
+		n = n.child(seg)
+	}
+	n.route = rt
+}
+
+// find walks the positional arguments down the tree, so each argument
+// is compared as a whole no matter what characters it holds. The most
+// specific child is tried first and when the arguments run out, a route
+// continuing with optional params wins over one ending here. The values
+// of the params are added to the map. With abbrev, a literal can also be
+// given by a prefix of it, when no other literal at that level starts
+// with the same prefix. Otherwise an AmbiguousError is returned.
+// Literals, exact or abbreviated, are tried before params. A route that
+// takes the arguments after its fields is found when none of the
+// children match them, and they are returned as rest.
+func (n *node) find(args []Argument, params map[string]string, abbrev bool) (rt *route, rest []Argument, err error) {
+	if n == nil {
+		return nil, nil, nil
+	}
+
+	if len(args) == 0 {
+		for _, c := range n.opts {
+			if rt, rest, err := c.find(args, params, abbrev); rt != nil || err != nil {
+				params[c.seg.name] = ""
+				return rt, rest, err
+			}
+		}
+		return n.route, nil, nil
+	}
+
+	arg := args[0].arg
+	words := []string{arg}
+	if abbrev {
+		word, err := n.expand(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+
+	for _, word := range words {
+		if rt, rest, err := n.lits[word].find(args[1:], params, abbrev); rt != nil || err != nil {
+			return rt, rest, err
+		}
+		for _, c := range n.alts {
+			if c.seg.has(word) {
+				if rt, rest, err := c.find(args[1:], params, abbrev); rt != nil || err != nil {
+					return rt, rest, err
+				}
+			}
+		}
+	}
+	for _, c := range n.params {
+		if rt, rest, err := c.find(args[1:], params, abbrev); rt != nil || err != nil {
+			params[c.seg.name] = arg
+			return rt, rest, err
+		}
+	}
+	for _, c := range n.opts {
+		if rt, rest, err := c.find(args[1:], params, abbrev); rt != nil || err != nil {
+			params[c.seg.name] = arg
+			return rt, rest, err
+		}
+		if rt, rest, err := c.find(args, params, abbrev); rt != nil || err != nil {
+			params[c.seg.name] = ""
+			return rt, rest, err
+		}
+	}
+	if n.route != nil && n.route.rest {
+		return n.route, args, nil
+	}
+	return nil, nil, nil
+}
+
+// exact returns the route registered for exactly the segments.
+func (n *node) exact(segs []segment) *route {
+	for _, seg := range segs {
+		if n == nil {
+			return nil
+		}
+		if seg.kind == segLiteral {
+			n = n.lits[seg.fld]
+			continue
+		}
+		var next *node
+		for _, children := range [][]*node{n.alts, n.params, n.opts} {
+			for _, c := range children {
+				if c.seg.fld == seg.fld {
+					next = c
+				}
+			}
+		}
+		n = next
+	}
+	if n == nil {
+		return nil
+	}
+	return n.route
+}
+
+// expand returns the literal, or alternation word, at this level of the
+// tree that the prefix is unique to.
+func (n *node) expand(prefix string) (string, error) {
+	var words []string
+	for lit := range n.lits {
+		words = append(words, lit)
+	}
+	for _, c := range n.alts {
+		words = append(words, c.seg.lits...)
+	}
+	return uniquePrefix(prefix, words)
+}
+
+// uniquePrefix returns the word that starts with the prefix. An empty
+// string is returned when there is none or when the prefix is a word
+// itself, and an AmbiguousError when there is more than one.
+func uniquePrefix(prefix string, words []string) (string, error) {
+	if prefix == "" {
+		return "", nil
+	}
+
+	var found []string
+	for _, word := range words {
+		if word == prefix {
+			return "", nil
+		}
+		if strings.HasPrefix(word, prefix) && !contains(found, word) {
+			found = append(found, word)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return found[0], nil
+	}
+	sort.Strings(found)
+	return "", &AmbiguousError{Arg: prefix, Candidates: found}
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -3,18 +3,39 @@ package cmdlnrouter
 import (
 	"errors"
 	"fmt"
-	"log"
 	"reflect"
-	"regexp"
 	"strconv"
 	"strings"
 )
 
 // Bitwise parsing mode identifiers.
+//
+// ParseOptSingleDashAsOpt accepts long options with a single dash, so
+// -config is the same as --config. ParseOptOnlyBeforeFirstCmd stops
+// looking for options at the first positional argument. A -- always
+// stops looking for options. ParseFailOnUnhandled returns an
+// UnhandledError for unknown options, when there is no UnhandledHandler.
+// ParseOptGoFlagStyle sets all three, to parse like the flag package.
+//
+// ParseOptGetoptLong parses like getopt_long, so short options can be
+// clustered (-abc is -a -b -c) and take their value attached, as in
+// -cfile or -c=file.
+//
+// ParseOptAbbrev accepts a unique prefix of a long option or of a
+// command word, so --conf is --config and "ip a" is "ip address". A
+// prefix that is not unique gives an AmbiguousError.
+//
+// ParseResponseFiles replaces an @path argument with the arguments in the
+// file at the path, split like a shell does, before any are parsed. The
+// file can name other response files. An argument starting with @@ is
+// taken as is, without the first @.
 const (
-	ParseOptSingleDashAsOpt = iota << 1
+	ParseOptSingleDashAsOpt = 1 << iota
 	ParseOptOnlyBeforeFirstCmd
 	ParseFailOnUnhandled
+	ParseOptGetoptLong
+	ParseOptAbbrev
+	ParseResponseFiles
 
 	ParseOptGoFlagStyle = ParseOptSingleDashAsOpt | ParseOptOnlyBeforeFirstCmd | ParseFailOnUnhandled
 )
@@ -29,17 +50,34 @@ type HandlerFunc func(*Context)
 
 func (f HandlerFunc) ServeCmdln(c *Context) { f(c) }
 
+// HandleE, HandlerE and HandlerFuncE are the same as Handle, Handler and
+// HandlerFunc, but they return an error which is passed back by Run.
+type HandleE func(*Context) error
+
+type HandlerE interface {
+	ServeCmdlnE(*Context) error
+}
+
+type HandlerFuncE func(*Context) error
+
+func (f HandlerFuncE) ServeCmdlnE(c *Context) error { return f(c) }
+
 type Router struct {
-	subs map[string]*SubRouter
+	subs   map[string]*SubRouter
+	parent *Router
 
 	opts   interface{}
 	cmds   interface{}
-	trees  map[*regexp.Regexp]Handle
+	tree   *node
 	cmdlst []string
 
 	helpTree []map[string][]int
 
-	mode int
+	mode      int
+	envPrefix string
+	config    string
+	profile   string
+	debug     string
 
 	// All of the handlers for issues
 	HandlerDone      Handle
@@ -47,15 +85,51 @@ type Router struct {
 	NotFoundHandler  Handle
 	UnhandledHandler Handle
 	PanicHandler     func(*Context, interface{})
+
+	// The exit codes Parse uses, DefaultExitCodes when nil
+	ExitCodes *ExitCodes
 }
 
-// Runs the PanicHandler if there is a panic
-func (r *Router) recovery(c *Context) {
-	if rcvr := recover(); rcvr != nil {
-		r.PanicHandler(c, rcvr)
+// Runs the PanicHandler if there is a panic, and returns it as a
+// PanicError. An exit from Context.Exit is returned as an ExitError
+// without running the PanicHandler. The router may be nil, when there
+// is no PanicHandler, and then the panic carries on.
+func (r *Router) recovery(c *Context, err *error) {
+	rcvr := recover()
+	if rcvr == nil {
+		return
 	}
+	if exit, ok := rcvr.(*ExitError); ok {
+		*err = exit.err()
+		return
+	}
+	if r == nil {
+		panic(rcvr)
+	}
+	r.PanicHandler(c, rcvr)
+	*err = &PanicError{Value: rcvr}
+}
+
+// call runs the handle, stopping at a Context.Exit so the HandlerDone
+// handler still runs afterwards.
+func call(handle HandleE, c *Context) (err error) {
+	defer func() {
+		if rcvr := recover(); rcvr != nil {
+			exit, ok := rcvr.(*ExitError)
+			if !ok {
+				panic(rcvr)
+			}
+			err = exit.err()
+		}
+	}()
+	return handle(c)
 }
 
+// JoinWithSpace joins the regular expressions of the fields of a command
+// line, the way routes were matched against the joined arguments.
+//
+// Deprecated: routes are matched against the arguments one at a time,
+// and nothing in the package uses this any more.
 func JoinWithSpace(ss []string) (s string) {
 	for i, x := range ss {
 		if i == 0 {
@@ -74,10 +148,15 @@ func JoinWithSpace(ss []string) (s string) {
 }
 
 func (r *Router) Handle(cmdln string, handle Handle) {
+	r.HandleE(cmdln,
+		func(c *Context) error {
+			handle(c)
+			return nil
+		},
+	)
+}
 
-	if r.trees == nil {
-		r.trees = make(map[*regexp.Regexp]Handle)
-	}
+func (r *Router) HandleE(cmdln string, handle HandleE) {
 
 	if r.cmdlst == nil {
 		r.cmdlst = make([]string, 0)
@@ -85,33 +164,32 @@ func (r *Router) Handle(cmdln string, handle Handle) {
 
 	r.cmdlst = append(r.cmdlst, cmdln)
 
-	cmdFlds := strings.Fields(regexp.QuoteMeta(cmdln))
+	cmdFlds := strings.Fields(cmdln)
 	initHelpTreeMaps(r, cmdFlds)
 
-	reCmd := regexp.MustCompile(`:(\w+)`)
-	reOptCmd := regexp.MustCompile(`:(\w+):`)
-
 	for i, v := range cmdFlds {
 		r.helpTree[i][v] = append(r.helpTree[i][v], i)
+	}
 
-		cmdOr := strings.Split(v, `\|`)
-		if len(cmdOr) > 1 {
-			cmdFlds[i] = fmt.Sprintf("(%s)", strings.Join(cmdOr, `|`))
+	// The route is added to the parent routers as well, so the top
+	// router can dispatch every command line in a single lookup.
+	rt := newRoute(cmdln, handle)
+	rt.router = r
+	for x := r; x != nil; x = x.parent {
+		if x.tree == nil {
+			x.tree = new(node)
 		}
-
-		cmdFlds[i] = string(reOptCmd.ReplaceAll([]byte(cmdFlds[i]), []byte(`(?P<$1>.[^\s]+)?`)))
-		cmdFlds[i] = string(reCmd.ReplaceAll([]byte(cmdFlds[i]), []byte(`(?P<$1>.[^\s]+)`)))
+		x.tree.insert(rt)
 	}
-
-	cmdSpacePlus := "^" + JoinWithSpace(cmdFlds) + "$"
-
-	// // Loop through all of the subcommands and add those handlers here
-	// log.Println("registering: ", cmdSpacePlus)
-
-	r.trees[regexp.MustCompile(cmdSpacePlus)] = handle
 }
 
+// Handler adds a handler for the command line. If the handler is also
+// a HandlerE, like a Router, the error it returns is kept.
 func (r *Router) Handler(cmdln string, handler Handler) {
+	if h, ok := handler.(HandlerE); ok {
+		r.HandlerE(cmdln, h)
+		return
+	}
 	r.Handle(cmdln,
 		func(c *Context) {
 			handler.ServeCmdln(c)
@@ -123,45 +201,253 @@ func (r *Router) HandlerFunc(cmdln string, handler HandlerFunc) {
 	r.Handler(cmdln, handler)
 }
 
+func (r *Router) HandlerE(cmdln string, handler HandlerE) {
+	r.HandleE(cmdln,
+		func(c *Context) error {
+			return handler.ServeCmdlnE(c)
+		},
+	)
+}
+
+func (r *Router) HandlerFuncE(cmdln string, handler HandlerFuncE) {
+	r.HandlerE(cmdln, handler)
+}
+
+// Arity lets the command line take from min to max positional arguments
+// after its fields, or any number from min when max is -1. They are
+// given to the handler by Context.Args, and a number out of the range is
+// returned as a MissingError. The command line has to be handled first.
+func (r *Router) Arity(cmdln string, min, max int) {
+	rt := r.tree.exact(newRoute(cmdln, nil).segs)
+	if rt == nil {
+		panic(fmt.Sprintf("cmdlnrouter: the arity of %q is set before it is handled", cmdln))
+	}
+	rt.rest, rt.min, rt.max = true, min, max
+}
+
 func (r *Router) ServeCmdln(c *Context) {
-	if r.PanicHandler != nil {
-		defer r.recovery(c)
+	r.ServeCmdlnE(c)
+}
+
+// ServeCmdlnE parses the commandline once for the router and all of its
+// subrouters, then runs the single handler the commandline resolves to.
+// The handlers for issues, the options and the command are taken from
+// the router owning the route, or the closest parent that has them set.
+// When an issue has no handler, it is returned as an error.
+func (r *Router) ServeCmdlnE(c *Context) (err error) {
+	srcs, err := r.parse(c)
+
+	params := make(map[string]string)
+	rt, rest, abbrevErr := r.tree.find(c.args, params, r.mode&ParseOptAbbrev != 0)
+	c.rest = rest
+
+	owner := r.find(c.args)
+	if rt != nil {
+		owner = rt.router
 	}
 
-	if len(c.Unhandled) > 0 && r.UnhandledHandler != nil {
-		r.UnhandledHandler(c)
-		return
+	defer owner.up(r, func(x *Router) bool { return x.PanicHandler != nil }).recovery(c, &err)
+
+	if x := owner.up(r, func(x *Router) bool { return x.opts != nil }); x != nil {
+		c.Options = x.opts
+		c.sources = srcs[x]
+	} else if c.Options == nil {
+		_, c.Options = parseArgsToMap(r.mode, c.rawArgs)
+	}
+	if c.debug {
+		writeSources(c.StdErr, c.Options, c.sources)
+	}
+
+	if err != nil {
+		return err
+	}
+	if abbrevErr != nil {
+		return abbrevErr
 	}
 
-	for rx, handle := range r.trees {
-		if rx.Match(c.cmdlnParse) {
+	if len(c.Unhandled) > 0 {
+		if x := owner.up(r, func(x *Router) bool { return x.UnhandledHandler != nil }); x != nil {
+			x.UnhandledHandler(c)
+			return nil
+		}
+		if r.mode&ParseFailOnUnhandled != 0 {
+			return &UnhandledError{Options: c.Unhandled}
+		}
+	}
 
-			parseCmds(rx, string(c.cmdlnParse), r.cmds)
-			c.Command = r.cmds
-			handle(c)
-			if r.HandlerDone != nil {
-				r.HandlerDone(c)
+	if rt != nil {
+		if err := r.missing(c, owner, rt); err != nil {
+			return err
+		}
+		if x := owner.up(r, func(x *Router) bool { return x.cmds != nil }); x != nil {
+			if err := parseCmds(params, x.cmds); err != nil {
+				return err
 			}
-			return
+			c.Command = x.cmds
 		}
+		err = call(rt.handle, c)
+		if x := owner.up(r, func(x *Router) bool { return x.HandlerDone != nil }); x != nil {
+			x.HandlerDone(c)
+		}
+		return err
+	}
+
+	if x := owner.up(r, func(x *Router) bool { return x.NotFoundHandler != nil }); x != nil {
+		x.NotFoundHandler(c)
+		return nil
 	}
 
-	if r.NotFoundHandler != nil {
-		r.NotFoundHandler(c)
+	nf := &NotFoundError{}
+	for _, a := range c.args {
+		nf.Args = append(nf.Args, a.arg)
+	}
+	return nf
+}
+
+// parse splits the raw commandline into the positional arguments and
+// the options, filling in the options of every router in the tree so
+// the arguments are only looked at once. Any issues found are returned
+// together as ParseErrors, and where the value of each option is from
+// by router.
+func (r *Router) parse(c *Context) (srcs map[*Router][]Source, err error) {
+	known, longs := make(map[string]bool), make(map[string]bool)
+	cfgNames, profNames := []string{"--config"}, []string{"--profile"}
+	r.walk(func(x *Router) {
+		if x.opts != nil {
+			addOptNames(known, x.opts)
+			addLongNames(longs, x.opts)
+			cfgNames = append(cfgNames, optNames("--config", x.opts)[1:]...)
+			profNames = append(profNames, optNames("--profile", x.opts)[1:]...)
+		}
+	})
+	cfg := r.up(nil, func(x *Router) bool { return x.config != "" })
+	for _, name := range append(cfgNames, profNames...) {
+		if _, ok := known[name]; cfg != nil && !ok {
+			known[name] = true
+		}
+	}
+	dbg := r.up(nil, func(x *Router) bool { return x.debug != "" })
+	if dbg != nil {
+		known[dbg.debug] = false
+	}
+
+	if r.mode&ParseResponseFiles != 0 {
+		args, err := expandArgs(c.rawArgs)
+		if err != nil {
+			return nil, ParseErrors{err}
+		}
+		c.rawArgs = args
+	}
+
+	argMap, end, errs := genArgMaps(r.mode, c.rawArgs, known)
+	used := make([]bool, len(c.rawArgs))
+
+	if dbg != nil {
+		for _, argv := range argMap[dbg.debug] {
+			markUsed(used, argv, false)
+			c.debug = true
+		}
+	}
+
+	var file *configFile
+	if cfg != nil {
+		path, perr := optValue(argMap, c.rawArgs, used, cfgNames...)
+		var profile string
+		var given bool
+		if perr == nil {
+			profile, given, perr = cfg.activeProfile(argMap, c.rawArgs, used, profNames)
+		}
+		if perr != nil {
+			errs = append(errs, perr)
+		} else {
+			var ferrs ParseErrors
+			file, ferrs = loadConfig(cfg.config, path, profile, given, longs)
+			errs = append(errs, ferrs...)
+		}
+		if file != nil {
+			c.profile = file.profile
+		}
+	}
+
+	srcs = make(map[*Router][]Source)
+	r.walk(func(x *Router) {
+		if x.opts != nil {
+			var serrs ParseErrors
+			srcs[x], serrs = setArgsToStruct(r.mode, c.rawArgs, argMap, used, x.opts, x.fallback(file))
+			errs = append(errs, serrs...)
+		}
+	})
+	if len(errs) > 0 {
+		err = errs
+	}
+
+	if len(srcs) == 0 {
+		c.args, c.Options = parseArgsToMap(r.mode, c.rawArgs)
 		return
 	}
+	c.args, c.Unhandled = splitArgs(c.rawArgs, used, end)
+	return
 }
 
 func (r *Router) Mode(i int) {
 	r.mode = i
 }
 
+// ConfigFile lets the options fall back to a config file, after the
+// environment and before their defaults. The file is the one given by
+// the --config option, which can also be a field of the options with a
+// short name, or else the first of $XDG_CONFIG_HOME/<name> and
+// $XDG_CONFIG_DIRS/<name> that exists, like ~/.config/app/config.ini. A
+// file ending in .json holds a JSON object, and any other file key =
+// value lines. The keys are the long options without the dashes. The
+// values of a profile, picked with the --profile option, are in a [name]
+// section, or in the "profiles" object of a JSON file. It is set on the
+// top router, and the options of all of its subrouters use the file.
+func (r *Router) ConfigFile(name string) {
+	if r.parent != nil {
+		panic("cmdlnrouter: the config file is set on a subrouter, it can only be set on the top router")
+	}
+	r.config = name
+}
+
+// Profile sets the profile of the config file that is used when none is
+// given by the --profile option or the <prefix>_PROFILE environment
+// variable, with the prefix from EnvPrefix. The values in the section of
+// the profile replace the shared values of the file. The default is
+// skipped when the file has no section for it. It is set on the top
+// router, with ConfigFile.
+func (r *Router) Profile(name string) {
+	if r.parent != nil {
+		panic("cmdlnrouter: the profile is set on a subrouter, it can only be set on the top router")
+	}
+	r.profile = name
+}
+
+// DebugFlag adds a flag, like --debug-options, that writes a table of
+// the options, their values and where each is from to StdErr before the
+// handler runs. It is set on the top router, and works for all of its
+// subrouters.
+func (r *Router) DebugFlag(name string) {
+	if r.parent != nil {
+		panic("cmdlnrouter: the debug flag is set on a subrouter, it can only be set on the top router")
+	}
+	r.debug = name
+}
+
+// EnvPrefix lets the options without an env tag fall back to an
+// environment variable named from their long option, so with the prefix
+// APP the option --dry-run falls back to APP_DRY_RUN. Subrouters use the
+// prefix of the closest router that has one.
+func (r *Router) EnvPrefix(prefix string) {
+	r.envPrefix = prefix
+}
+
 func (r *Router) SubCmd(s string) *SubRouter {
 	if r.subs == nil {
 		r.subs = make(map[string]*SubRouter)
 	}
 
-	r.subs[s] = &SubRouter{subcmd: s, Router: new(Router)}
+	r.subs[s] = &SubRouter{subcmd: s, Router: &Router{parent: r}}
 	return r.subs[s]
 }
 
@@ -188,103 +474,112 @@ func initHelpTreeMaps(r *Router, cmdFlds []string) {
 	}
 }
 
-// Parse will start the parsing process for the commandline
+// Parse will start the parsing process for the commandline. When there
+// is an error, it is written to Stderr and the process exits with the
+// code from the ExitCodes of the router.
 func Parse(args []string, handler Handler) {
-	parse, opts, extra := parseArgs(args, handler)
+	err := Run(args, handler)
 
-	c := NewContext()
-	c.Options = opts
-	c.Unhandled = extra
-	c.cmdlnAsRaw = []byte(strings.Join(args, " "))
-	c.cmdlnParse = []byte(Join(parse, " "))
-	handler.ServeCmdln(c)
-	switch handler.(type) {
+	codes := &DefaultExitCodes
+	switch r := handler.(type) {
 	case *Router:
-		for _, v := range handler.(*Router).subs {
-			Parse(args, v)
+		if r.ExitCodes != nil {
+			codes = r.ExitCodes
 		}
 	case *SubRouter:
-		for _, v := range handler.(*SubRouter).subs {
-			Parse(args, v)
+		if r.ExitCodes != nil {
+			codes = r.ExitCodes
 		}
 	}
+
+	if code := codes.Code(err); code != 0 {
+		if _, ok := err.(*ExitError); !ok {
+			fmt.Fprintln(stderr, err)
+		}
+		osExit(code)
+	}
 }
 
-func parseCmds(rx *regexp.Regexp, cmdtxt string, cmd interface{}) {
-	if cmd == nil {
-		return
+// Run is the same as Parse, but returns the error from parsing the
+// commandline or from the handler. Issues with the options are returned
+// together as ParseErrors holding a ConversionError or ConfigError for
+// each, while NotFoundError and UnhandledError are returned when there
+// is no handler set for the issue.
+func Run(args []string, handler Handler) error {
+	c := NewContext()
+	c.cmdlnAsRaw = []byte(strings.Join(args, " "))
+	c.rawArgs = args
+
+	switch handler.(type) {
+	case *Router, *SubRouter:
+		// Routers parse the arguments themselves
+	default:
+		c.args, c.Options = parseArgsToMap(0, args)
 	}
 
-	names := rx.SubexpNames()
-	values := rx.FindStringSubmatch(cmdtxt)
+	if h, ok := handler.(HandlerE); ok {
+		return call(h.ServeCmdlnE, c)
+	}
+	return call(func(c *Context) error { handler.ServeCmdln(c); return nil }, c)
+}
+
+func parseCmds(params map[string]string, cmd interface{}) error {
+	if cmd == nil {
+		return nil
+	}
 
 	if r, ok := cmd.(map[string]interface{}); ok {
-		for i, v := range names {
-			if len(v) > 0 {
-				r[v] = values[i]
-			}
+		for k, v := range params {
+			r[k] = v
 		}
-		return
+		return nil
 	}
 
-	val := reflect.ValueOf(reflect.ValueOf(cmd).Interface())
-	if val.Elem().Type().Kind() != reflect.Struct {
-		log.Fatal("Only stucts can be passed in [1]. Please check the type of the interface{}. Found: ", val.Elem().Type().Kind())
+	elm, err := structOf(cmd)
+	if err != nil {
+		return err
 	}
 
 	// For now we can only accept flat command. No structs or slices.
-	// Just the following type:
-	//    *string
+	// Just pointers to the types that an option can take a value of,
+	// like *string, *int or *time.Duration, and the custom types.
 
-	elm := val.Elem()
+	var errs ParseErrors
 	for i := 0; i < elm.NumField(); i++ {
 		vField := elm.Field(i)
 		tField := elm.Type().Field(i)
 
-		for n, v := range names {
-			if strings.ToLower(tField.Name) == strings.ToLower(v) {
-				if vField.CanSet() {
-					vField.Set(reflect.ValueOf(&values[n]))
+		for n, v := range params {
+			if strings.ToLower(tField.Name) == strings.ToLower(n) {
+				switch {
+				case !vField.CanSet():
+					return &ConfigError{Type: elm.Type(), Field: tField.Name, Err: errors.New("the field is not exported")}
+				case !custom(vField.Type()) && (vField.Kind() != reflect.Ptr || !convertible(vField.Type().Elem())):
+					return &ConfigError{Type: elm.Type(), Field: tField.Name, Err: fmt.Errorf("the type %s is not supported", vField.Type())}
+				}
+				if err := setValue(vField, v, tField.Tag); err != nil {
+					errs = append(errs, &ConversionError{Option: n, Value: v, Type: vField.Type(), Err: err})
 				}
 				break
 			}
 		}
 	}
 
-	return
-}
-
-func parseArgs(args []string, handler Handler) ([]Argument, interface{}, map[string]string) {
-	// create a map of the options we will be looking for
-	var pags []Argument
-	var opts interface{}
-	var xtra map[string]string
-
-	switch handler.(type) {
-	case *Router:
-		r := handler.(*Router)
-		if r.opts != nil {
-			pags, opts, xtra = parseArgsToStruct(r.mode, args, r.opts)
-		} else {
-			pags, opts = parseArgsToMap(r.mode, args)
-		}
-	case *SubRouter:
-		r := handler.(*SubRouter)
-		if r.opts != nil {
-			pags, opts, xtra = parseArgsToStruct(r.mode, args, r.opts)
-		} else {
-			pags, opts = parseArgsToMap(r.mode, args)
-		}
+	if len(errs) > 0 {
+		return errs
 	}
-
-	return pags, opts, xtra
+	return nil
 }
 
 func parseArgsToMap(mode int, args []string) (pags []Argument, opts map[string]*string) {
 
 	opts = make(map[string]*string)
 	for i, field := range args {
-		if field[0] == '-' {
+		if field == "--" {
+			pags = append(pags, toArguments(args[i+1:])...)
+			break
+		}
+		if isOpt(field) {
 			opts[field] = func(a []string, i int) (r *string) {
 				if len(args) > i {
 					r = &a[i]
@@ -293,181 +588,370 @@ func parseArgsToMap(mode int, args []string) (pags []Argument, opts map[string]*
 			}(args, i+1)
 			continue
 		}
-		if i-1 >= 0 && args[i-1][0] == '-' {
+		if i-1 >= 0 && isOpt(args[i-1]) {
 			continue // skip because it should have already be processed
 		}
+		if mode&ParseOptOnlyBeforeFirstCmd != 0 {
+			pags = append(pags, toArguments(args[i:])...)
+			break
+		}
 		pags = append(pags, Argument{arg: field})
 	}
 
 	return
 }
 
-type M struct {
-	k int // key index
-	v int // value index
+func toArguments(args []string) (pags []Argument) {
+	for _, arg := range args {
+		pags = append(pags, Argument{arg: arg})
+	}
+	return
 }
 
-func genArgMaps(args []string) (flatMap map[string]M, tmpPags []Argument) {
-	flatMap = make(map[string]M)
+// isOpt reports if the argument looks like an option. Empty arguments
+// and a single dash are always positional.
+func isOpt(arg string) bool {
+	return len(arg) > 1 && arg[0] == '-'
+}
 
-	for i, arg := range args {
-		tmpPags = append(tmpPags, Argument{arg: arg})
+type M struct {
+	k   int    // key index
+	v   int    // value index
+	val string // the value, when it is part of the key argument
+	opt string // the option name, after any expansion
+}
 
-		if arg[0] == '-' {
-			v := i + 1
-			if v > len(args) {
-				v = -1
-			}
-			if strings.Contains(arg, "=") { // This doesn't check for inside of quotes
-				arg = strings.Split(arg, "=")[0]
-				v = -2
+// genArgMaps finds the options in the arguments. The known map holds
+// the option names from the options structs, with true for the ones
+// that take a value, so the value is not taken as an option or as the
+// first command. The index of the argument where looking for options
+// stopped, because of a -- or the first command, is returned as end.
+// Ambiguous abbreviations of long options are returned as errors.
+// Every occurrence of an option is kept, in the order found.
+func genArgMaps(mode int, args []string, known map[string]bool) (flatMap map[string][]M, end int, errs ParseErrors) {
+	flatMap = make(map[string][]M)
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return flatMap, i, errs
+		}
+		if !isOpt(arg) {
+			if mode&ParseOptOnlyBeforeFirstCmd != 0 {
+				return flatMap, i, errs
 			}
-			flatMap[arg] = M{i, v} // always return index of the value to take.
 			continue
 		}
-		if i-1 >= 0 && args[i-1][0] == '-' && flatMap[args[i-1]].v != -2 {
-			continue // skip because it should have already be processed
+
+		v := i + 1
+		if v >= len(args) {
+			v = -1
+		}
+		var val string
+		if kv := strings.SplitN(arg, "=", 2); len(kv) == 2 { // This doesn't check for inside of quotes
+			arg, v, val = kv[0], -2, kv[1]
+		}
+		if _, ok := known[arg]; !ok && mode&ParseOptSingleDashAsOpt != 0 && len(arg) > 1 && arg[1] != '-' {
+			if _, ok := known["-"+arg]; ok {
+				arg = "-" + arg
+			}
+		}
+		if _, ok := known[arg]; !ok && mode&ParseOptAbbrev != 0 && len(arg) > 2 && arg[1] == '-' {
+			var longs []string
+			for k := range known {
+				if strings.HasPrefix(k, "--") {
+					longs = append(longs, k)
+				}
+			}
+			long, err := uniquePrefix(arg, longs)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if long != "" {
+				arg = long
+			}
+		}
+		if _, ok := known[arg]; !ok && args[i][1] != '-' && (mode&ParseOptGetoptLong != 0 || repeatedFlag(args[i], known)) {
+			if names, cval, attached, ok := splitCluster(args[i], known); ok {
+				for _, name := range names[:len(names)-1] {
+					flatMap[name] = append(flatMap[name], M{i, -1, "", name})
+				}
+				arg, val = names[len(names)-1], cval
+				if attached {
+					v = -2
+				}
+			}
+		}
+		flatMap[arg] = append(flatMap[arg], M{i, v, val, arg}) // always return index of the value to take.
+
+		if v > 0 && known[arg] {
+			i++ // skip the value
 		}
 	}
 
-	return
+	return flatMap, len(args), errs
+}
+
+// repeatedFlag reports if the argument is a known short flag repeated,
+// like -vvv, which is split in every mode.
+func repeatedFlag(arg string, known map[string]bool) bool {
+	takesVal, ok := known[arg[:2]]
+	return ok && !takesVal && len(arg) > 2 && strings.Trim(arg[1:], arg[1:2]) == ""
+}
+
+// splitCluster splits clustered short options, like -abc, into -a, -b
+// and -c. The last one may take a value, which is either attached, as in
+// -cfile or -c=file, or the next argument. It returns false when one of
+// the options is not known.
+func splitCluster(arg string, known map[string]bool) (names []string, val string, attached, ok bool) {
+	for j, c := range arg[1:] {
+		name := "-" + string(c)
+		takesVal, found := known[name]
+		if !found {
+			return nil, "", false, false
+		}
+		names = append(names, name)
+
+		if rest := arg[1+j+len(string(c)):]; takesVal {
+			if rest != "" {
+				return names, strings.TrimPrefix(rest, "="), true, true
+			}
+			break
+		}
+	}
+	return names, "", false, true
 }
 
-func parseCmdlnVal(data M, args []string, defaultVal string) (val string, err error) {
+// parseCmdlnVal returns the value of the option, which is either part
+// of the option argument, as in --key=<value>, or the next argument.
+func parseCmdlnVal(data M, args []string) (val string, err error) {
 	switch data.v {
 	case -1:
-		// This is a flag that is expecting a default value
-		if len(defaultVal) > 0 {
-			val = defaultVal
-		} else {
-			err = errors.New("No default value found.")
-		}
+		// This is an option at the end, without a value
+		err = errors.New("the option needs a value")
 		return
 	case -2:
 		// This is an arguement that looks like --key=<value>
-		vals := strings.Split(args[data.k], "=")
-		if len(vals) > 1 {
-			val = vals[1]
-		} else {
-			err = errors.New("No value found.")
-		}
+		val = data.val
 		return
 	}
-	// This is for if a bool is at the end
 	if data.v < len(args) {
 		val = args[data.v]
 	}
 	return
 }
 
-func parseDefaultVal(arg string) (a, b string) {
-	arg = a
-	if strings.Contains(arg, "=") {
-		kv := strings.Split(arg, "=")
-		if len(kv) == 2 {
-			a = kv[0]
-			b = kv[1]
-		}
+func parseArgsToStruct(mode int, args []string, optsIn interface{}) (pags []Argument, opts interface{}, unhandled map[string]string, err error) {
+	known := make(map[string]bool)
+	addOptNames(known, optsIn)
+
+	argMap, end, errs := genArgMaps(mode, args, known)
+	used := make([]bool, len(args))
+
+	_, serrs := setArgsToStruct(mode, args, argMap, used, optsIn, fallback{})
+	if errs = append(errs, serrs...); len(errs) > 0 {
+		err = errs
 	}
-	return
+	pags, unhandled = splitArgs(args, used, end)
+
+	return pags, optsIn, unhandled, err
 }
 
-func parseArgsToStruct(mode int, args []string, optsIn interface{}) (pags []Argument, opts interface{}, unhandled map[string]string) {
-	//	var err error
-	var removeArg Argument
+// addOptNames adds the option names of the options struct to known,
+// with true for the ones that take a value.
+func addOptNames(known map[string]bool, optsIn interface{}) {
+	elm, err := structOf(optsIn)
+	if err != nil {
+		return // setArgsToStruct returns the error
+	}
 
-	argMap, argTmpMap := genArgMaps(args)
+	for i := 0; i < elm.NumField(); i++ {
+		tField := elm.Type().Field(i)
+		optShort, optLong, _ := parseCmdlnTag(tField.Tag.Get("cmdln"))
+		for _, v := range []string{optShort, optLong} {
+			if v != "" {
+				known[v] = tField.Type != reflect.TypeOf(new(bool)) && !counter(tField)
+			}
+		}
+		if tField.Type == reflect.TypeOf(new(bool)) && optLong != "" {
+			known[negated(optLong)] = false
+		}
+	}
+}
 
-	val := reflect.ValueOf(reflect.ValueOf(optsIn).Interface())
-	if val.Elem().Type().Kind() != reflect.Struct {
-		log.Fatal("Only stucts can be passed in [2]. Please check the type of the interface{}. Found: ", val.Elem().Type().Kind())
+// setArgsToStruct fills in the options struct from the arguments, and
+// marks the arguments it has taken as used. It returns where the value
+// of each field is from. Values that can not be converted and fields
+// that can not be used are returned as errors.
+func setArgsToStruct(mode int, args []string, argMap map[string][]M, used []bool, optsIn interface{}, fb fallback) (srcs []Source, errs ParseErrors) {
+	elm, err := structOf(optsIn)
+	if err != nil {
+		return nil, ParseErrors{err}
 	}
-	elm := val.Elem()
 
-	// For now we can only accept flat options. No structs or slices.
+	// For now we can only accept flat options. No structs.
 	// Just the following types:
-	//    *int
-	//    *float
+	//    *int, *int8 ... *int64, *uint ... *uint64
+	//    *float32, *float64
 	//    *string
-	//    *bool
+	//    *time.Duration, *time.Time, *os.FileMode, *ByteSize
+	//    *bool, Count
+	// The types that take a value can also be in a slice, like []string,
+	// or a map with string keys, like map[string]int. So can any type
+	// that implements encoding.TextUnmarshaler or flag.Value, or is
+	// given to RegisterType, which can also be used as is.
 
 	// Add the data to the struct, using type hints to apply the data
+	given := make(map[string]bool)
 	for i := 0; i < elm.NumField(); i++ {
 		vField := elm.Field(i)
 		tField := elm.Type().Field(i)
 
+		tags := tField.Tag.Get("cmdln")
+		if tags == "" {
+			continue
+		}
+
 		if vField.CanSet() == false {
-			log.Println("Please make sure the ", tField.Name, " field is exportable.")
+			errs = append(errs, &ConfigError{Type: elm.Type(), Field: tField.Name, Err: errors.New("the field is not exported")})
+			continue
+		}
+
+		if !supported(vField.Type()) {
+			errs = append(errs, &ConfigError{Type: elm.Type(), Field: tField.Name, Err: fmt.Errorf("the type %s is not supported", vField.Type())})
 			continue
 		}
 
-		tags := tField.Tag.Get("cmdln")
 		optShort, optLong, _ := parseCmdlnTag(tags)
 
-		for _, v := range []string{optShort, optLong} {
-			if argv, ok := argMap[v]; ok {
+		srcs = append(srcs, Source{Field: tField.Name, optShort: optShort, optLong: optLong})
+		src := &srcs[len(srcs)-1]
 
-				_, defaultVal := parseDefaultVal(v)
+		names := []string{optShort, optLong}
+		if _, ok := vField.Interface().(*bool); ok && optLong != "" {
+			names = append(names, negated(optLong))
+		}
 
-				val, err := parseCmdlnVal(argv, args, defaultVal)
-				if err != nil {
-					log.Println("Error getting value. Err: ", err)
+		argvs := occurrences(argMap, names...)
+		if len(argvs) == 0 {
+			if vals, from, name, ok := fb.lookup(tField, optLong); ok {
+				given[optName(optShort, optLong)] = true
+				src.From, src.Name, src.Value = from, name, strings.Join(vals, " ")
+				if err := setFrom(vField, tField, vals...); err != nil {
+					errs = append(errs, &ConversionError{Option: name, Value: src.Value, Type: vField.Type(), Err: err})
 					continue
 				}
-
-				switch vField.Interface().(type) {
-				case *int:
-					vPtr, err := strconv.Atoi(val)
-					if err != nil {
-						log.Println("Error converting to int. Err: ", err)
-						continue
-					}
-					vField.Set(reflect.ValueOf(&vPtr))
-					argTmpMap[argv.k] = removeArg
-				case *float64:
-					vPtr, err := strconv.ParseFloat(val, 10)
-					if err != nil {
-						log.Println("Error converting to int. Err: ", err)
-						continue
+				for _, val := range vals {
+					if err := checkValue(elm, tField, name, val); err != nil {
+						errs = append(errs, err)
 					}
-					vField.Set(reflect.ValueOf(&vPtr))
-					argTmpMap[argv.k] = removeArg
-				case *string:
-					vField.Set(reflect.ValueOf(&val))
-					argTmpMap[argv.k] = removeArg
-				case *bool:
-					vPtr := true
-					vField.Set(reflect.ValueOf(&vPtr))
-					argTmpMap[argv.k] = removeArg
-				default:
-					log.Println("Not parse-able. Found Kind: ", vField.Type())
 				}
+				continue
 			}
+			if def, ok := tField.Tag.Lookup("default"); ok {
+				src.From, src.Value = SourceDefault, def
+				if err := setFrom(vField, tField, def); err != nil {
+					errs = append(errs, &ConfigError{Type: elm.Type(), Field: tField.Name, Err: fmt.Errorf("the default %q: %w", def, err)})
+				}
+			}
+			continue
+		}
+		given[optName(optShort, optLong)] = true
+		src.From, src.Name = SourceFlag, argvs[len(argvs)-1].opt
+
+		if counter(tField) {
+			for _, argv := range argvs {
+				markUsed(used, argv, false)
+				if argv.v == -2 {
+					errs = append(errs, &ConversionError{Option: argv.opt, Value: argv.val, Type: vField.Type(), Err: errors.New("the option does not take a value")})
+				}
+			}
+			setCount(vField, len(argvs))
+			src.Value = strconv.Itoa(len(argvs))
+			continue
 		}
-	}
 
-	unhandled = make(map[string]string)
+		if repeatable(vField.Type()) {
+			vField.Set(reflect.Zero(vField.Type())) // the commandline replaces what was there
+		}
+
+		for _, argv := range argvs {
+			if _, ok := vField.Interface().(*bool); ok {
+				markUsed(used, argv, false)
+				vPtr, err := boolValue(argv)
+				if err != nil {
+					errs = append(errs, &ConversionError{Option: argv.opt, Value: argv.val, Type: vField.Type(), Err: err})
+					continue
+				}
+				vField.Set(reflect.ValueOf(&vPtr))
+				src.Value = strconv.FormatBool(vPtr)
+				continue
+			}
+
+			val, err := parseCmdlnVal(argv, args)
+			if err != nil {
+				markUsed(used, argv, false)
+				errs = append(errs, &ConversionError{Option: argv.opt, Type: vField.Type(), Err: err})
+				continue
+			}
 
-	for _, v := range argTmpMap {
-		if val, ok := argMap[v.arg]; ok {
-			if val.v < len(args) { // because unhandled may assume an extra param...
-				unhandled[v.arg] = args[val.v]
-			} else {
-				unhandled[v.arg] = ""
+			markUsed(used, argv, true)
+			src.Value = strings.TrimSpace(src.Value + " " + val)
+			if err := setValue(vField, val, tField.Tag); err != nil {
+				errs = append(errs, &ConversionError{Option: argv.opt, Value: val, Type: vField.Type(), Err: err})
+				continue
 			}
-			argTmpMap[val.k] = removeArg
-			if val.v != -1 && val.v < len(args) {
-				argTmpMap[val.v] = removeArg
+			if err := checkValue(elm, tField, argv.opt, val); err != nil {
+				errs = append(errs, err)
 			}
+		}
+	}
+
+	return srcs, append(errs, checkGroups(elm, given)...)
+}
+
+// markUsed marks the option, and the argument after it when it holds
+// the value, as used.
+func markUsed(used []bool, argv M, withVal bool) {
+	used[argv.k] = true
+	if withVal && argv.v >= 0 && argv.v < len(used) {
+		used[argv.v] = true
+	}
+}
+
+// splitArgs returns the arguments that are not used as positional
+// arguments, and the options that are not used as unhandled, along with
+// the value that follows them. From end on every argument is positional,
+// apart from a -- at the end.
+func splitArgs(args []string, used []bool, end int) (pags []Argument, unhandled map[string]string) {
+	unhandled = make(map[string]string)
+
+	for i := 0; i < end; i++ {
+		if used[i] {
 			continue
 		}
-		if v != removeArg {
-			pags = append(pags, v)
+		if !isOpt(args[i]) {
+			pags = append(pags, Argument{arg: args[i]})
+			continue
+		}
+		if kv := strings.SplitN(args[i], "=", 2); len(kv) == 2 {
+			unhandled[kv[0]] = kv[1]
+			continue
+		}
+		unhandled[args[i]] = ""
+		if i+1 < end && !used[i+1] && !isOpt(args[i+1]) {
+			unhandled[args[i]] = args[i+1]
+			i++
 		}
 	}
 
-	// log.Println(pags, optsIn, unhandled)
-	return pags, optsIn, unhandled
+	if end < len(args) && args[end] == "--" {
+		end++
+	}
+	pags = append(pags, toArguments(args[end:])...)
+
+	return
 }
 
 func Join(args []Argument, s string) string {
@@ -4,7 +4,19 @@ import "testing"
 import "strings"
 import "reflect"
 import "encoding/json"
+import "math/rand"
 import "regexp"
+import "fmt"
+import "errors"
+import "sort"
+import "strconv"
+import "os"
+import "flag"
+import "io"
+import "net"
+import "net/url"
+import "time"
+import "path/filepath"
 
 func TestParseArgsToMap(t *testing.T) {
 
@@ -149,7 +161,7 @@ func TestParseArgsToStruct(t *testing.T) {
 		c := new(TestContext)
 		c.opts = &tst.strt
 
-		a, b, _ := parseArgsToStruct(0, tst.test, c.opts)
+		a, b, _, _ := parseArgsToStruct(0, tst.test, c.opts)
 		a1 := strings.Join(tst.pags, " ")
 		a2 := Join(a, " ")
 
@@ -157,7 +169,7 @@ func TestParseArgsToStruct(t *testing.T) {
 		b2 := string(b1)
 
 		if a1 != a2 {
-			// t.Error("Expected:", a1, "Found:", a2)
+			t.Error("Expected:", a1, "Found:", a2)
 		}
 		if tst.opts != b2 {
 			t.Error("Expected:", tst.opts, "Found:", b2)
@@ -168,19 +180,19 @@ func TestParseArgsToStruct(t *testing.T) {
 func TestParseCommand(t *testing.T) {
 
 	tests := []struct {
-		rgex *regexp.Regexp
+		rout string
 		cmln string
 		cmds string
 		strt TestCommandStruct1
 	}{
 		{
-			regexp.MustCompile(`^example$`),
+			"example",
 			"example",
 			`{"Ample":null}`,
 			TestCommandStruct1{},
 		},
 		{
-			regexp.MustCompile(`^(?P<ample>\w+)$`),
+			":ample",
 			"example",
 			`{"Ample":"example"}`,
 			TestCommandStruct1{},
@@ -189,7 +201,10 @@ func TestParseCommand(t *testing.T) {
 
 	for _, tst := range tests {
 
-		parseCmds(tst.rgex, tst.cmln, &tst.strt)
+		tree, params := new(node), make(map[string]string)
+		tree.insert(newRoute(tst.rout, nil))
+		tree.find([]Argument{{arg: tst.cmln}}, params, false)
+		parseCmds(params, &tst.strt)
 
 		a1, _ := json.Marshal(tst.strt)
 		a2 := string(a1)
@@ -201,9 +216,2062 @@ func TestParseCommand(t *testing.T) {
 
 	b1 := map[string]interface{}{"ample": "example"}
 	b2 := make(map[string]interface{})
-	parseCmds(regexp.MustCompile(`^(?P<ample>\w+)$`), "example", b2)
+	tree, params := new(node), make(map[string]string)
+	tree.insert(newRoute(":ample", nil))
+	tree.find([]Argument{{arg: "example"}}, params, false)
+	parseCmds(params, b2)
 
 	if !reflect.DeepEqual(b1, b2) {
 		t.Error("Expected:", b1, "Found:", b2)
 	}
 }
+
+func TestRouteSpecificity(t *testing.T) {
+
+	cmdlns := []string{
+		"example",
+		"example :func",
+		"example ask something",
+		"example :func something",
+		"example ask :what:",
+		"example ask|tell something",
+	}
+
+	tests := []struct {
+		cmln string
+		want string
+	}{
+		{"example", "example"},
+		{"example version", "example :func"},
+		{"example ask something", "example ask something"},
+		{"example tell something", "example ask|tell something"},
+		{"example say something", "example :func something"},
+		{"example ask", "example ask :what:"},
+		{"example ask nothing", "example ask :what:"},
+	}
+
+	for run := 0; run < 100; run++ {
+		for _, tst := range tests {
+			var found string
+
+			r := new(Router)
+			for _, i := range rand.Perm(len(cmdlns)) {
+				cmdln := cmdlns[i]
+				r.Handle(cmdln, func(c *Context) { found = cmdln })
+			}
+			Parse(strings.Fields(tst.cmln), r)
+
+			if tst.want != found {
+				t.Fatal("Run:", run, "Cmdln:", tst.cmln, "Expected:", tst.want, "Found:", found)
+			}
+		}
+	}
+}
+
+func TestRouteMatchArguments(t *testing.T) {
+
+	tests := []struct {
+		rout   string
+		args   []string
+		match  bool
+		params map[string]string
+	}{
+		{"say :what", []string{"say", "hello world"}, true, map[string]string{"what": "hello world"}},
+		{"say :what", []string{"say", "hello", "world"}, false, map[string]string{}},
+		{"say :what", []string{"say", ""}, true, map[string]string{"what": ""}},
+		{"say :what", []string{"say"}, false, map[string]string{}},
+		{"say :what", []string{"say", "x"}, true, map[string]string{"what": "x"}},
+		{"say :what:", []string{"say"}, true, map[string]string{"what": ""}},
+		{"say :what: now", []string{"say", "now"}, true, map[string]string{"what": ""}},
+		{"say :what: now", []string{"say", "hi", "now"}, true, map[string]string{"what": "hi"}},
+		{"match a.b*", []string{"match", "a.b*"}, true, map[string]string{}},
+		{"match a.b*", []string{"match", "aXbbb"}, false, map[string]string{}},
+		{"say|tell :what", []string{"tell", "(.*)"}, true, map[string]string{"what": "(.*)"}},
+		{"say|tell :what", []string{"say|tell", "x"}, false, map[string]string{}},
+		{"say hello world", []string{"say", "hello world"}, false, map[string]string{}},
+	}
+
+	for _, tst := range tests {
+		var args []Argument
+		for _, v := range tst.args {
+			args = append(args, Argument{arg: v})
+		}
+
+		tree, params := new(node), make(map[string]string)
+		tree.insert(newRoute(tst.rout, nil))
+		rt, _, _ := tree.find(args, params, false)
+		ok := rt != nil
+		if tst.match != ok {
+			t.Error("Route:", tst.rout, "Args:", tst.args, "Expected:", tst.match, "Found:", ok)
+		}
+		if !reflect.DeepEqual(tst.params, params) {
+			t.Error("Route:", tst.rout, "Args:", tst.args, "Expected:", tst.params, "Found:", params)
+		}
+	}
+
+	var found string
+	r := new(Router)
+	r.Handle("say :what", func(c *Context) { found = c.Command.(map[string]interface{})["what"].(string) })
+	r.Command(make(map[string]interface{}))
+	Parse([]string{"say", "hello world"}, r)
+
+	if found != "hello world" {
+		t.Error("Expected:", "hello world", "Found:", found)
+	}
+}
+
+func TestRouteTree(t *testing.T) {
+
+	tree := new(node)
+	for _, cmdln := range []string{
+		"get :id",
+		"get :name delete",
+		"get all",
+		"get all|every :kind:",
+		"put :id :value:",
+	} {
+		tree.insert(newRoute(cmdln, nil))
+	}
+
+	tests := []struct {
+		cmln   string
+		rout   string
+		params map[string]string
+	}{
+		{"get 1", "get :id", map[string]string{"id": "1"}},
+		{"get 1 delete", "get :name delete", map[string]string{"name": "1"}},
+		{"get all", "get all", map[string]string{}},
+		{"get every user", "get all|every :kind:", map[string]string{"kind": "user"}},
+		{"get all delete", "get all|every :kind:", map[string]string{"kind": "delete"}},
+		{"put 1", "put :id :value:", map[string]string{"id": "1", "value": ""}},
+		{"put", "", map[string]string{}},
+		{"get 1 2 3", "", map[string]string{}},
+	}
+
+	for _, tst := range tests {
+		var args []Argument
+		for _, v := range strings.Fields(tst.cmln) {
+			args = append(args, Argument{arg: v})
+		}
+
+		var found string
+		params := make(map[string]string)
+		if rt, _, _ := tree.find(args, params, false); rt != nil {
+			found = rt.cmdln
+		}
+		if tst.rout != found {
+			t.Error("Cmdln:", tst.cmln, "Expected:", tst.rout, "Found:", found)
+		}
+		if !reflect.DeepEqual(tst.params, params) {
+			t.Error("Cmdln:", tst.cmln, "Expected:", tst.params, "Found:", params)
+		}
+	}
+}
+
+// benchCmdlns returns a few hundred command lines, the size of a larger
+// tool, and the arguments that match the last one registered.
+func benchCmdlns() (cmdlns []string, args []Argument) {
+	for i := 0; i < 50; i++ {
+		for _, cmd := range []string{
+			"list :filter:",
+			"get :id",
+			"create :name :kind:",
+			"delete :id",
+			"update :id :field :value",
+			"watch all|changed :id:",
+		} {
+			cmdlns = append(cmdlns, fmt.Sprintf("resource%d %s", i, cmd))
+		}
+	}
+
+	for _, v := range []string{"resource49", "watch", "changed", "42"} {
+		args = append(args, Argument{arg: v})
+	}
+	return
+}
+
+// benchRegexScan builds a regex per command line the way Handle used
+// to, so the command tree can be compared to scanning them one by one.
+func benchRegexScan(cmdlns []string) (rxs []*regexp.Regexp) {
+	reCmd := regexp.MustCompile(`:(\w+)`)
+	reOptCmd := regexp.MustCompile(`:(\w+):`)
+
+	for _, cmdln := range cmdlns {
+		cmdFlds := strings.Fields(regexp.QuoteMeta(cmdln))
+		for i, v := range cmdFlds {
+			if cmdOr := strings.Split(v, `\|`); len(cmdOr) > 1 {
+				cmdFlds[i] = fmt.Sprintf("(%s)", strings.Join(cmdOr, `|`))
+			}
+			cmdFlds[i] = reOptCmd.ReplaceAllString(cmdFlds[i], `(?P<$1>[^\s]+)?`)
+			cmdFlds[i] = reCmd.ReplaceAllString(cmdFlds[i], `(?P<$1>[^\s]+)`)
+		}
+
+		rx := cmdFlds[0]
+		for _, v := range cmdFlds[1:] {
+			if v[len(v)-1] == '?' {
+				rx += `\s*` + v
+			} else {
+				rx += `\s+` + v
+			}
+		}
+		rxs = append(rxs, regexp.MustCompile("^"+rx+"$"))
+	}
+	return
+}
+
+func BenchmarkRouteRegexScan(b *testing.B) {
+	cmdlns, args := benchCmdlns()
+	rxs := benchRegexScan(cmdlns)
+	cmdln := []byte(Join(args, " "))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, rx := range rxs {
+			if rx.Match(cmdln) {
+				rx.FindSubmatch(cmdln)
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkRouteTree(b *testing.B) {
+	cmdlns, args := benchCmdlns()
+	tree := new(node)
+	for _, cmdln := range cmdlns {
+		tree.insert(newRoute(cmdln, nil))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if rt, _, _ := tree.find(args, make(map[string]string), false); rt == nil {
+			b.Fatal("Expected a route to be found.")
+		}
+	}
+}
+
+type TestOptionsStruct2 struct {
+	Config *string `cmdln:"-c,--config"`
+	Quiet  *bool   `cmdln:"-q,--quiet"`
+}
+
+type TestSubOptionsStruct2 struct {
+	NoLong *string `cmdln:"-n,--"`
+	Force  *bool   `cmdln:"-f,--force"`
+}
+
+func TestSingleDispatch(t *testing.T) {
+
+	tests := []struct {
+		test     []string
+		handled  []string
+		notFound string
+		options  string
+		setOpts  string
+	}{
+		{[]string{"example"}, []string{"example"}, "", "root", ""},
+		{[]string{"example", "sub", "kicks"}, []string{"sub kicks"}, "", "sub", ""},
+		{[]string{"example", "sub", "more"}, []string{"more"}, "", "sub", ""},
+		{[]string{"-c", "x", "example", "sub", "-f", "more", "place", "-n", "y"}, []string{"more place"}, "", "sub", "x f y"},
+		{[]string{"example", "-q", "sub", "more", "place"}, []string{"more place"}, "", "sub", "q"},
+		{[]string{"example", "sub", "bogus"}, nil, "sub", "", ""},
+		{[]string{"example", "sub", "more", "bogus"}, nil, "sub", "", ""},
+		{[]string{"bogus"}, nil, "root", "", ""},
+	}
+
+	for _, tst := range tests {
+		var handled []string
+		var notFound, options string
+
+		var opt TestOptionsStruct2
+		var subopt TestSubOptionsStruct2
+
+		r := new(Router)
+		r.Options(&opt)
+		r.NotFoundHandler = func(c *Context) { notFound += "root" }
+
+		sub := r.SubCmd("example sub")
+		sub.Options(&subopt)
+		sub.NotFoundHandler = func(c *Context) { notFound += "sub" }
+
+		subSub := sub.SubCmd("more")
+		subSub.Handle("", func(c *Context) { handled = append(handled, "more") })
+		subSub.Handle("place", func(c *Context) { handled = append(handled, "more place") })
+		sub.Handle("kicks", func(c *Context) { handled = append(handled, "sub kicks") })
+		r.Handle("example", func(c *Context) { handled = append(handled, "example") })
+
+		r.HandlerDone = func(c *Context) {
+			switch c.Options.(type) {
+			case *TestOptionsStruct2:
+				options = "root"
+			case *TestSubOptionsStruct2:
+				options = "sub"
+			}
+		}
+
+		Parse(tst.test, r)
+
+		if !reflect.DeepEqual(tst.handled, handled) {
+			t.Error("Args:", tst.test, "Expected:", tst.handled, "Found:", handled)
+		}
+		if tst.notFound != notFound {
+			t.Error("Args:", tst.test, "Expected:", tst.notFound, "Found:", notFound)
+		}
+		if tst.options != options {
+			t.Error("Args:", tst.test, "Expected:", tst.options, "Found:", options)
+		}
+
+		var setOpts []string
+		if opt.Config != nil {
+			setOpts = append(setOpts, *opt.Config)
+		}
+		if opt.Quiet != nil {
+			setOpts = append(setOpts, "q")
+		}
+		if subopt.Force != nil {
+			setOpts = append(setOpts, "f")
+		}
+		if subopt.NoLong != nil {
+			setOpts = append(setOpts, *subopt.NoLong)
+		}
+		if tst.setOpts != strings.Join(setOpts, " ") {
+			t.Error("Args:", tst.test, "Expected:", tst.setOpts, "Found:", setOpts)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+
+	errHandler := errors.New("handler error")
+
+	newRouter := func() *Router {
+		var opt TestOptionsStruct1
+
+		r := new(Router)
+		r.Options(&opt)
+		r.HandleE("example", func(c *Context) error { return nil })
+		r.HandleE("example fail", func(c *Context) error { return errHandler })
+		r.HandlerFuncE("example fail func", HandlerFuncE(func(c *Context) error { return errHandler }))
+		r.SubCmd("sub").HandleE("fail", func(c *Context) error { return errHandler })
+		return r
+	}
+
+	var notFound *NotFoundError
+	var unhandled *UnhandledError
+	var conversion *ConversionError
+
+	if err := Run([]string{"example"}, newRouter()); err != nil {
+		t.Error("Expected: nil Found:", err)
+	}
+	for _, args := range [][]string{{"example", "fail"}, {"example", "fail", "func"}, {"sub", "fail"}} {
+		if err := Run(args, newRouter()); err != errHandler {
+			t.Error("Args:", args, "Expected:", errHandler, "Found:", err)
+		}
+	}
+
+	if err := Run([]string{"bogus", "cmd"}, newRouter()); !errors.As(err, &notFound) {
+		t.Error("Expected: *NotFoundError Found:", err)
+	} else if strings.Join(notFound.Args, " ") != "bogus cmd" {
+		t.Error("Expected: bogus cmd Found:", notFound.Args)
+	}
+
+	r := newRouter()
+	r.NotFoundHandler = func(c *Context) {}
+	if err := Run([]string{"bogus"}, r); err != nil {
+		t.Error("Expected: nil Found:", err)
+	}
+
+	if err := Run([]string{"example", "--bogus"}, newRouter()); err != nil {
+		t.Error("Expected: nil Found:", err)
+	}
+
+	r = newRouter()
+	r.Mode(ParseFailOnUnhandled)
+	if err := Run([]string{"example", "--bogus"}, r); !errors.As(err, &unhandled) {
+		t.Error("Expected: *UnhandledError Found:", err)
+	} else if _, ok := unhandled.Options["--bogus"]; !ok {
+		t.Error("Expected: --bogus Found:", unhandled.Options)
+	}
+
+	if err := Run([]string{"example", "--aye", "one"}, newRouter()); !errors.As(err, &conversion) {
+		t.Error("Expected: *ConversionError Found:", err)
+	} else if conversion.Option != "--aye" || conversion.Value != "one" || conversion.Type != reflect.TypeOf(new(int)) {
+		t.Error("Expected: --aye one *int Found:", conversion.Option, conversion.Value, conversion.Type)
+	}
+}
+
+type TestOptionsStruct3 struct {
+	A *int     `cmdln:"-a,--aye"`
+	B *float64 `cmdln:"-b,--bee"`
+	c *string  `cmdln:"-c,--sea"`
+	D *uintptr `cmdln:"-d,--dei"`
+	e *string
+}
+
+func TestParseErrors(t *testing.T) {
+
+	var opt TestOptionsStruct3
+
+	_, _, _, err := parseArgsToStruct(0, []string{"-a", "one", "--bee", "two", "example"}, &opt)
+
+	errs, ok := err.(ParseErrors)
+	if !ok {
+		t.Fatal("Expected: ParseErrors Found:", err)
+	}
+
+	var found []string
+	for _, e := range errs {
+		switch e := e.(type) {
+		case *ConversionError:
+			found = append(found, e.Option+"="+e.Value)
+		case *ConfigError:
+			found = append(found, e.Field)
+		}
+	}
+
+	expected := []string{"-a=one", "--bee=two", "c", "D"}
+	sort.Strings(expected)
+	sort.Strings(found)
+	if !reflect.DeepEqual(expected, found) {
+		t.Error("Expected:", expected, "Found:", found)
+	}
+
+	var config *ConfigError
+	var notStruct int
+	if _, _, _, err := parseArgsToStruct(0, []string{"example"}, &notStruct); !errors.As(err, &config) {
+		t.Error("Expected: *ConfigError Found:", err)
+	}
+
+	r := new(Router)
+	r.Options(TestOptionsStruct1{})
+	r.Handle("example", func(c *Context) {})
+	if err := Run([]string{"example"}, r); !errors.As(err, &config) {
+		t.Error("Expected: *ConfigError Found:", err)
+	}
+
+	r = new(Router)
+	r.Command(&notStruct)
+	r.Handle("example :ample", func(c *Context) {})
+	if err := Run([]string{"example", "x"}, r); !errors.As(err, &config) {
+		t.Error("Expected: *ConfigError Found:", err)
+	}
+}
+
+func TestContextExit(t *testing.T) {
+
+	var steps []string
+
+	r := new(Router)
+	r.HandlerDone = func(c *Context) { steps = append(steps, "done") }
+	r.PanicHandler = func(c *Context, rcvr interface{}) { steps = append(steps, "panic") }
+	r.Handle("exit :code", func(c *Context) {
+		defer func() { steps = append(steps, "deferred") }()
+		code, _ := strconv.Atoi(c.Command.(map[string]interface{})["code"].(string))
+		c.Exit(code)
+		steps = append(steps, "after exit")
+	})
+	r.Handle("panic", func(c *Context) { panic("oops") })
+	r.Command(make(map[string]interface{}))
+
+	var exit *ExitError
+	if err := Run([]string{"exit", "3"}, r); !errors.As(err, &exit) || exit.Code != 3 {
+		t.Error("Expected: exit status 3 Found:", err)
+	}
+	if strings.Join(steps, " ") != "deferred done" {
+		t.Error("Expected: deferred done Found:", steps)
+	}
+
+	steps = nil
+	if err := Run([]string{"exit", "0"}, r); err != nil {
+		t.Error("Expected: nil Found:", err)
+	}
+	if strings.Join(steps, " ") != "deferred done" {
+		t.Error("Expected: deferred done Found:", steps)
+	}
+
+	steps = nil
+	var panicked *PanicError
+	if err := Run([]string{"panic"}, r); !errors.As(err, &panicked) || panicked.Value != "oops" {
+		t.Error("Expected: panic: oops Found:", err)
+	}
+	if strings.Join(steps, " ") != "panic" {
+		t.Error("Expected: panic Found:", steps)
+	}
+
+	r.NotFoundHandler = func(c *Context) { c.Exit(4) }
+	if err := Run([]string{"bogus"}, r); !errors.As(err, &exit) || exit.Code != 4 {
+		t.Error("Expected: exit status 4 Found:", err)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+
+	codes := &ExitCodes{NotFound: 10, Unhandled: 11, BadValue: 12, Panic: 13, Handler: 14}
+
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{nil, 0},
+		{&ExitError{Code: 5}, 5},
+		{&NotFoundError{}, 10},
+		{&UnhandledError{}, 11},
+		{ParseErrors{&ConversionError{}}, 12},
+		{&ConfigError{}, 12},
+		{&PanicError{}, 13},
+		{errors.New("handler"), 14},
+	}
+
+	for _, tst := range tests {
+		if code := codes.Code(tst.err); tst.code != code {
+			t.Error("Error:", tst.err, "Expected:", tst.code, "Found:", code)
+		}
+	}
+
+	var exited []int
+	var errOut strings.Builder
+	osExit = func(code int) { exited = append(exited, code) }
+	stderr = &errOut
+	defer func() { osExit, stderr = os.Exit, os.Stderr }()
+
+	r := new(Router)
+	r.ExitCodes = codes
+	r.Handle("exit", func(c *Context) { c.Exit(7) })
+	r.HandleE("fail", func(c *Context) error { return errors.New("handler") })
+	r.Handle("ok", func(c *Context) {})
+	r.PanicHandler = func(c *Context, rcvr interface{}) {}
+
+	for _, args := range []string{"exit", "fail", "ok", "bogus"} {
+		Parse([]string{args}, r)
+	}
+	Parse([]string{"bogus"}, new(Router))
+	Parse([]string{"x"}, HandlerFunc(func(c *Context) { c.Exit(3) }))
+
+	if !reflect.DeepEqual([]int{7, 14, 10, 2, 3}, exited) {
+		t.Error("Expected:", []int{7, 14, 10, 2, 3}, "Found:", exited)
+	}
+	if !strings.Contains(errOut.String(), "handler\n") {
+		t.Error("Expected: the error on stderr Found:", errOut.String())
+	}
+
+	// A Context.Exit in a handler that is not a router.
+	var exit *ExitError
+	if err := Run([]string{"x"}, HandlerFunc(func(c *Context) { c.Exit(3) })); !errors.As(err, &exit) || exit.Code != 3 {
+		t.Error("Expected: *ExitError Found:", err)
+	}
+}
+
+type TestGoFlagOptions struct {
+	Config *string  `cmdln:"-,--config"`
+	Count  *int     `cmdln:"-,--count"`
+	Ratio  *float64 `cmdln:"-,--ratio"`
+	Debug  *bool    `cmdln:"-,--debug"`
+}
+
+func TestParseOptGoFlagStyle(t *testing.T) {
+
+	tests := [][]string{
+		{"-config", "x", "cmd", "-debug"},
+		{"--config=x", "-debug", "cmd"},
+		{"-config=a=b", "cmd"},
+		{"-config=", "cmd"},
+		{"-debug", "cmd", "sub"},
+		{"-config", "x", "-debug"},
+		{"-count", "3", "--", "-debug"},
+		{"-ratio", "2.5", "-config", "-debug"},
+		{"cmd", "-debug"},
+		{"-", "-debug"},
+		{"-=x", "run"},
+		{"--", "--"},
+		{"-bogus"},
+		{"-count", "x"},
+		{"-count"},
+		{},
+	}
+
+	for _, args := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		expected := TestGoFlagOptions{
+			Config: fs.String("config", "", ""),
+			Count:  fs.Int("count", 0, ""),
+			Ratio:  fs.Float64("ratio", 0, ""),
+			Debug:  fs.Bool("debug", false, ""),
+		}
+		expectedErr := fs.Parse(args)
+
+		// Only keep the flags that were set, the same as the router
+		set := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if !set["config"] {
+			expected.Config = nil
+		}
+		if !set["count"] {
+			expected.Count = nil
+		}
+		if !set["ratio"] {
+			expected.Ratio = nil
+		}
+		if !set["debug"] {
+			expected.Debug = nil
+		}
+
+		var found TestGoFlagOptions
+		var foundArgs []string
+
+		r := new(Router)
+		r.Mode(ParseOptGoFlagStyle)
+		r.Options(&found)
+		r.NotFoundHandler = func(c *Context) {
+			for _, a := range c.args {
+				foundArgs = append(foundArgs, a.arg)
+			}
+		}
+		foundErr := Run(args, r)
+
+		if (expectedErr != nil) != (foundErr != nil) {
+			t.Error("Args:", args, "Expected:", expectedErr, "Found:", foundErr)
+			continue
+		}
+		if expectedErr != nil {
+			continue
+		}
+
+		if !reflect.DeepEqual(expected, found) {
+			e, _ := json.Marshal(expected)
+			f, _ := json.Marshal(found)
+			t.Error("Args:", args, "Expected:", string(e), "Found:", string(f))
+		}
+		if strings.Join(fs.Args(), " ") != strings.Join(foundArgs, " ") || len(fs.Args()) != len(foundArgs) {
+			t.Error("Args:", args, "Expected:", fs.Args(), "Found:", foundArgs)
+		}
+	}
+}
+
+func TestParseModes(t *testing.T) {
+
+	tests := []struct {
+		mode   int
+		test   []string
+		pags   []string
+		opts   string
+		unhand []string
+	}{
+		{0, []string{"example", "-a", "1"}, []string{"example"}, `{"A":1,"B":null,"C":null,"D":null}`, nil},
+		{0, []string{"example", "-aye", "1"}, []string{"example"}, `{"A":null,"B":null,"C":null,"D":null}`, []string{"-aye"}},
+		{ParseOptSingleDashAsOpt, []string{"example", "-aye", "1"}, []string{"example"}, `{"A":1,"B":null,"C":null,"D":null}`, nil},
+		{ParseOptSingleDashAsOpt, []string{"-bee=2.5", "example"}, []string{"example"}, `{"A":null,"B":2.5,"C":null,"D":null}`, nil},
+		{ParseOptOnlyBeforeFirstCmd, []string{"-d", "example", "-a", "1"}, []string{"example", "-a", "1"}, `{"A":null,"B":null,"C":null,"D":true}`, nil},
+		{ParseOptOnlyBeforeFirstCmd, []string{"-c", "x", "example", "--sea", "y"}, []string{"example", "--sea", "y"}, `{"A":null,"B":null,"C":"x","D":null}`, nil},
+		{0, []string{"-c", "x", "--", "example", "--sea", "y"}, []string{"example", "--sea", "y"}, `{"A":null,"B":null,"C":"x","D":null}`, nil},
+	}
+
+	for _, tst := range tests {
+		var opt TestOptionsStruct1
+
+		a, b, u, _ := parseArgsToStruct(tst.mode, tst.test, &opt)
+		if strings.Join(tst.pags, " ") != Join(a, " ") {
+			t.Error("Args:", tst.test, "Expected:", tst.pags, "Found:", Join(a, " "))
+		}
+		if b1, _ := json.Marshal(b); tst.opts != string(b1) {
+			t.Error("Args:", tst.test, "Expected:", tst.opts, "Found:", string(b1))
+		}
+		var unhand []string
+		for k := range u {
+			unhand = append(unhand, k)
+		}
+		if !reflect.DeepEqual(tst.unhand, unhand) {
+			t.Error("Args:", tst.test, "Expected:", tst.unhand, "Found:", unhand)
+		}
+	}
+
+	a, b := parseArgsToMap(ParseOptOnlyBeforeFirstCmd, []string{"-s", "flag", "example", "-x", "y"})
+	if Join(a, " ") != "example -x y" || len(b) != 1 || *b["-s"] != "flag" {
+		t.Error("Expected: example -x y map[-s:flag] Found:", Join(a, " "), b)
+	}
+}
+
+type TestGetoptOptions struct {
+	All    *bool   `cmdln:"-a,--all"`
+	Brief  *bool   `cmdln:"-b,--brief"`
+	Config *string `cmdln:"-c,--config"`
+	Level  *int    `cmdln:"-l,--level"`
+}
+
+func TestParseOptGetoptLong(t *testing.T) {
+
+	tests := []struct {
+		test   []string
+		pags   []string
+		opts   string
+		unhand string
+		err    bool
+	}{
+		{[]string{"-ab"}, nil, `{"All":true,"Brief":true,"Config":null,"Level":null}`, "", false},
+		{[]string{"-ba", "cmd"}, []string{"cmd"}, `{"All":true,"Brief":true,"Config":null,"Level":null}`, "", false},
+		{[]string{"-abc", "file"}, nil, `{"All":true,"Brief":true,"Config":"file","Level":null}`, "", false},
+		{[]string{"-abcfile", "cmd"}, []string{"cmd"}, `{"All":true,"Brief":true,"Config":"file","Level":null}`, "", false},
+		{[]string{"-cfile"}, nil, `{"All":null,"Brief":null,"Config":"file","Level":null}`, "", false},
+		{[]string{"-c=file"}, nil, `{"All":null,"Brief":null,"Config":"file","Level":null}`, "", false},
+		{[]string{"-c", "file"}, nil, `{"All":null,"Brief":null,"Config":"file","Level":null}`, "", false},
+		{[]string{"-ckey=value"}, nil, `{"All":null,"Brief":null,"Config":"key=value","Level":null}`, "", false},
+		{[]string{"-c", "-a"}, nil, `{"All":null,"Brief":null,"Config":"-a","Level":null}`, "", false},
+		{[]string{"--config", "file"}, nil, `{"All":null,"Brief":null,"Config":"file","Level":null}`, "", false},
+		{[]string{"--config=file"}, nil, `{"All":null,"Brief":null,"Config":"file","Level":null}`, "", false},
+		{[]string{"--config=a=b"}, nil, `{"All":null,"Brief":null,"Config":"a=b","Level":null}`, "", false},
+		{[]string{"--config="}, nil, `{"All":null,"Brief":null,"Config":"","Level":null}`, "", false},
+		{[]string{"-l3", "cmd"}, []string{"cmd"}, `{"All":null,"Brief":null,"Config":null,"Level":3}`, "", false},
+		{[]string{"-al", "3", "cmd"}, []string{"cmd"}, `{"All":true,"Brief":null,"Config":null,"Level":3}`, "", false},
+		{[]string{"cmd", "-a", "sub"}, []string{"cmd", "sub"}, `{"All":true,"Brief":null,"Config":null,"Level":null}`, "", false},
+		{[]string{"-a", "--", "-b", "cmd"}, []string{"-b", "cmd"}, `{"All":true,"Brief":null,"Config":null,"Level":null}`, "", false},
+		{[]string{"-abx"}, nil, `{"All":null,"Brief":null,"Config":null,"Level":null}`, "-abx", false},
+		{[]string{"-lx"}, nil, `{"All":null,"Brief":null,"Config":null,"Level":null}`, "", true},
+		{[]string{"-c"}, nil, `{"All":null,"Brief":null,"Config":null,"Level":null}`, "", true},
+	}
+
+	for _, tst := range tests {
+		var opt TestGetoptOptions
+
+		a, b, u, err := parseArgsToStruct(ParseOptGetoptLong, tst.test, &opt)
+		if strings.Join(tst.pags, " ") != Join(a, " ") || len(tst.pags) != len(a) {
+			t.Error("Args:", tst.test, "Expected:", tst.pags, "Found:", Join(a, " "))
+		}
+		if b1, _ := json.Marshal(b); tst.opts != string(b1) {
+			t.Error("Args:", tst.test, "Expected:", tst.opts, "Found:", string(b1))
+		}
+		var unhand []string
+		for k := range u {
+			unhand = append(unhand, k)
+		}
+		if tst.unhand != strings.Join(unhand, " ") {
+			t.Error("Args:", tst.test, "Expected:", tst.unhand, "Found:", unhand)
+		}
+		if tst.err != (err != nil) {
+			t.Error("Args:", tst.test, "Expected:", tst.err, "Found:", err)
+		}
+	}
+}
+
+type TestAbbrevOptions struct {
+	Config *string `cmdln:"-f,--config"`
+	Count  *int    `cmdln:"-n,--count"`
+	Color  *bool   `cmdln:"-,--color"`
+}
+
+func TestParseOptAbbrev(t *testing.T) {
+
+	tests := []struct {
+		mode  int
+		test  []string
+		found string
+		opts  string
+		ambig []string
+	}{
+		{ParseOptAbbrev, []string{"ip", "address"}, "ip address", `{"Config":null,"Count":null,"Color":null}`, nil},
+		{ParseOptAbbrev, []string{"ip", "addre"}, "ip address", `{"Config":null,"Count":null,"Color":null}`, nil},
+		{ParseOptAbbrev, []string{"ip", "addrl"}, "ip addrlabel", `{"Config":null,"Count":null,"Color":null}`, nil},
+		{ParseOptAbbrev, []string{"ip", "l", "sh"}, "ip link show|set", `{"Config":null,"Count":null,"Color":null}`, nil},
+		{ParseOptAbbrev, []string{"ip", "r"}, "ip :object", `{"Config":null,"Count":null,"Color":null}`, nil},
+		{ParseOptAbbrev, []string{"ip", "addr"}, "", `{"Config":null,"Count":null,"Color":null}`, []string{"address", "addrlabel"}},
+		{ParseOptAbbrev, []string{"ip", "l", "s"}, "", `{"Config":null,"Count":null,"Color":null}`, []string{"set", "show"}},
+		{ParseOptAbbrev, []string{"--conf", "x", "ip", "link"}, "ip link", `{"Config":"x","Count":null,"Color":null}`, nil},
+		{ParseOptAbbrev, []string{"--cou=3", "--col", "ip", "link"}, "ip link", `{"Config":null,"Count":3,"Color":true}`, nil},
+		{ParseOptAbbrev, []string{"--co", "x", "ip", "link"}, "", `{"Config":null,"Count":null,"Color":null}`, []string{"--color", "--config", "--count"}},
+		{ParseOptAbbrev, []string{"-=x", "ip", "link"}, "ip link", `{"Config":null,"Count":null,"Color":null}`, nil},
+		{ParseOptAbbrev, []string{"--=x", "ip", "link"}, "ip link", `{"Config":null,"Count":null,"Color":null}`, nil},
+		{0, []string{"ip", "a"}, "ip :object", `{"Config":null,"Count":null,"Color":null}`, nil},
+		{0, []string{"--conf", "x", "ip", "link"}, "ip link", `{"Config":null,"Count":null,"Color":null}`, nil},
+	}
+
+	for _, tst := range tests {
+		var opt TestAbbrevOptions
+		var found string
+
+		r := new(Router)
+		r.Mode(tst.mode)
+		r.Options(&opt)
+		for _, cmdln := range []string{"ip address", "ip addrlabel", "ip link", "ip link show|set", "ip :object"} {
+			cmdln := cmdln
+			r.Handle(cmdln, func(c *Context) { found = cmdln })
+		}
+
+		err := Run(tst.test, r)
+
+		if tst.found != found {
+			t.Error("Args:", tst.test, "Expected:", tst.found, "Found:", found)
+		}
+		if b, _ := json.Marshal(opt); tst.opts != string(b) {
+			t.Error("Args:", tst.test, "Expected:", tst.opts, "Found:", string(b))
+		}
+
+		var ambig *AmbiguousError
+		if errors.As(err, &ambig) {
+			if !reflect.DeepEqual(tst.ambig, ambig.Candidates) {
+				t.Error("Args:", tst.test, "Expected:", tst.ambig, "Found:", ambig.Candidates)
+			}
+		} else if tst.ambig != nil {
+			t.Error("Args:", tst.test, "Expected: *AmbiguousError Found:", err)
+		}
+	}
+}
+
+type TestSliceOptions struct {
+	Include []string  `cmdln:"-I,--include,Add a search path" cmdvar:"dir"`
+	Ports   []int     `cmdln:"-p,--port" cmdsep:","`
+	Weights []float64 `cmdln:"-w,--weight"`
+	Name    *string   `cmdln:"-n,--name"`
+}
+
+func TestParseSliceOptions(t *testing.T) {
+
+	tests := []struct {
+		test []string
+		pags []string
+		opts string
+		err  bool
+	}{
+		{[]string{"cmd"}, []string{"cmd"}, `{"Include":null,"Ports":null,"Weights":null,"Name":null}`, false},
+		{[]string{"-I", "a", "-I", "b", "cmd"}, []string{"cmd"}, `{"Include":["a","b"],"Ports":null,"Weights":null,"Name":null}`, false},
+		{[]string{"-I", "a", "--include", "b", "-I=c"}, nil, `{"Include":["a","b","c"],"Ports":null,"Weights":null,"Name":null}`, false},
+		{[]string{"--include=a,b"}, nil, `{"Include":["a,b"],"Ports":null,"Weights":null,"Name":null}`, false},
+		{[]string{"-p", "80,443", "--port", "8080"}, nil, `{"Include":null,"Ports":[80,443,8080],"Weights":null,"Name":null}`, false},
+		{[]string{"-w", "0.5", "-w", "1.5"}, nil, `{"Include":null,"Ports":null,"Weights":[0.5,1.5],"Name":null}`, false},
+		{[]string{"-n", "a", "-n", "b"}, nil, `{"Include":null,"Ports":null,"Weights":null,"Name":"b"}`, false},
+		{[]string{"-p", "80,x", "-p", "90"}, nil, `{"Include":null,"Ports":[90],"Weights":null,"Name":null}`, true},
+		{[]string{"-I", "a", "-I"}, nil, `{"Include":["a"],"Ports":null,"Weights":null,"Name":null}`, true},
+	}
+
+	for _, tst := range tests {
+		var opt TestSliceOptions
+
+		a, b, _, err := parseArgsToStruct(0, tst.test, &opt)
+		if strings.Join(tst.pags, " ") != Join(a, " ") || len(tst.pags) != len(a) {
+			t.Error("Args:", tst.test, "Expected:", tst.pags, "Found:", Join(a, " "))
+		}
+		if b1, _ := json.Marshal(b); tst.opts != string(b1) {
+			t.Error("Args:", tst.test, "Expected:", tst.opts, "Found:", string(b1))
+		}
+		if tst.err != (err != nil) {
+			t.Error("Args:", tst.test, "Expected:", tst.err, "Found:", err)
+		}
+	}
+
+	// The commandline replaces what was in the slice before.
+	opt := TestSliceOptions{Include: []string{"x"}}
+	parseArgsToStruct(0, []string{"-I", "a"}, &opt)
+	if strings.Join(opt.Include, " ") != "a" {
+		t.Error("Expected: [a] Found:", opt.Include)
+	}
+
+	r := new(Router)
+	r.Options(&TestSliceOptions{})
+	if help := r.Help(); !strings.Contains(help, "-I, --include dir...") || !strings.Contains(help, "-p, --port Ports...") {
+		t.Error("Expected repeatable options in help, Found:", help)
+	}
+}
+
+type TestMapOptions struct {
+	Define map[string]string `cmdln:"-D,--define" cmdvar:"key=value"`
+	Limit  map[string]int    `cmdln:"-l,--limit" cmdsep:","`
+}
+
+func TestParseMapOptions(t *testing.T) {
+
+	tests := []struct {
+		test []string
+		pags []string
+		opts string
+		err  bool
+	}{
+		{[]string{"cmd"}, []string{"cmd"}, `{"Define":null,"Limit":null}`, false},
+		{[]string{"-D", "env=prod", "-D", "region=eu", "cmd"}, []string{"cmd"}, `{"Define":{"env":"prod","region":"eu"},"Limit":null}`, false},
+		{[]string{"--define=env=prod", "-D", "url=a=b", "-D", "empty="}, nil, `{"Define":{"empty":"","env":"prod","url":"a=b"},"Limit":null}`, false},
+		{[]string{"-l", "cpu=2,mem=512", "--limit", "disk=10"}, nil, `{"Define":null,"Limit":{"cpu":2,"disk":10,"mem":512}}`, false},
+		{[]string{"-D", "env=prod", "-D", "env=dev"}, nil, `{"Define":{"env":"prod"},"Limit":null}`, true},
+		{[]string{"-l", "cpu=1,cpu=2"}, nil, `{"Define":null,"Limit":null}`, true},
+		{[]string{"-D", "env", "-D", "a=b"}, nil, `{"Define":{"a":"b"},"Limit":null}`, true},
+		{[]string{"-l", "cpu=x"}, nil, `{"Define":null,"Limit":null}`, true},
+	}
+
+	for _, tst := range tests {
+		var opt TestMapOptions
+
+		a, b, _, err := parseArgsToStruct(0, tst.test, &opt)
+		if strings.Join(tst.pags, " ") != Join(a, " ") || len(tst.pags) != len(a) {
+			t.Error("Args:", tst.test, "Expected:", tst.pags, "Found:", Join(a, " "))
+		}
+		if b1, _ := json.Marshal(b); tst.opts != string(b1) {
+			t.Error("Args:", tst.test, "Expected:", tst.opts, "Found:", string(b1))
+		}
+		if tst.err != (err != nil) {
+			t.Error("Args:", tst.test, "Expected:", tst.err, "Found:", err)
+		}
+	}
+
+	var opt TestMapOptions
+	_, _, _, err := parseArgsToStruct(ParseOptGetoptLong, []string{"-Denv=prod", "-D", "env", "-D", "env=dev"}, &opt)
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatal("Expected: 2 ParseErrors Found:", err)
+	}
+	for _, e := range errs {
+		var cerr *ConversionError
+		if !errors.As(e, &cerr) || cerr.Option != "-D" {
+			t.Error("Expected: ConversionError for -D Found:", e)
+		}
+	}
+	if opt.Define["env"] != "prod" {
+		t.Error("Expected: env=prod Found:", opt.Define)
+	}
+}
+
+type TestCountOptions struct {
+	Verbose Count `cmdln:"-v,--verbose,More output"`
+	Quiet   *int  `cmdln:"-q,--quiet" cmdcount:"true"`
+	Debug   *bool `cmdln:"-d,--debug"`
+	Level   *int  `cmdln:"-l,--level"`
+}
+
+func TestParseCountOptions(t *testing.T) {
+
+	tests := []struct {
+		mode   int
+		test   []string
+		pags   []string
+		opts   string
+		unhand string
+		err    bool
+	}{
+		{0, []string{"cmd"}, []string{"cmd"}, `{"Verbose":0,"Quiet":null,"Debug":null,"Level":null}`, "", false},
+		{0, []string{"-v", "cmd"}, []string{"cmd"}, `{"Verbose":1,"Quiet":null,"Debug":null,"Level":null}`, "", false},
+		{0, []string{"-v", "-v", "--verbose"}, nil, `{"Verbose":3,"Quiet":null,"Debug":null,"Level":null}`, "", false},
+		{0, []string{"-vvv", "cmd", "-qq"}, []string{"cmd"}, `{"Verbose":3,"Quiet":2,"Debug":null,"Level":null}`, "", false},
+		{0, []string{"-ddd"}, nil, `{"Verbose":0,"Quiet":null,"Debug":true,"Level":null}`, "", false},
+		{0, []string{"-vq"}, nil, `{"Verbose":0,"Quiet":null,"Debug":null,"Level":null}`, "-vq", false},
+		{0, []string{"-lll"}, nil, `{"Verbose":0,"Quiet":null,"Debug":null,"Level":null}`, "-lll", false},
+		{ParseOptGetoptLong, []string{"-vqv", "-l", "2"}, nil, `{"Verbose":2,"Quiet":1,"Debug":null,"Level":2}`, "", false},
+		{0, []string{"--verbose=2"}, nil, `{"Verbose":1,"Quiet":null,"Debug":null,"Level":null}`, "", true},
+	}
+
+	for _, tst := range tests {
+		var opt TestCountOptions
+
+		a, b, u, err := parseArgsToStruct(tst.mode, tst.test, &opt)
+		if strings.Join(tst.pags, " ") != Join(a, " ") || len(tst.pags) != len(a) {
+			t.Error("Args:", tst.test, "Expected:", tst.pags, "Found:", Join(a, " "))
+		}
+		if b1, _ := json.Marshal(b); tst.opts != string(b1) {
+			t.Error("Args:", tst.test, "Expected:", tst.opts, "Found:", string(b1))
+		}
+		var unhand []string
+		for k := range u {
+			unhand = append(unhand, k)
+		}
+		if tst.unhand != strings.Join(unhand, " ") {
+			t.Error("Args:", tst.test, "Expected:", tst.unhand, "Found:", unhand)
+		}
+		if tst.err != (err != nil) {
+			t.Error("Args:", tst.test, "Expected:", tst.err, "Found:", err)
+		}
+	}
+
+	r := new(Router)
+	r.Options(&TestCountOptions{})
+	if help := r.Help(); !strings.Contains(help, "-v, --verbose...    More output") {
+		t.Error("Expected a repeatable flag in help, Found:", help)
+	}
+}
+
+type TestBoolOptions struct {
+	Color *bool `cmdln:"-c,--color,Colored output"`
+	Debug *bool `cmdln:"-d"`
+	Cache *bool `cmdln:"-,--cache"`
+}
+
+func TestParseBoolOptions(t *testing.T) {
+
+	tests := []struct {
+		mode   int
+		test   []string
+		opts   string
+		unhand string
+		err    bool
+	}{
+		{0, []string{}, `{"Color":null,"Debug":null,"Cache":null}`, "", false},
+		{0, []string{"--color", "-d"}, `{"Color":true,"Debug":true,"Cache":null}`, "", false},
+		{0, []string{"--no-color", "--no-cache"}, `{"Color":false,"Debug":null,"Cache":false}`, "", false},
+		{0, []string{"--color", "--no-color"}, `{"Color":false,"Debug":null,"Cache":null}`, "", false},
+		{0, []string{"--no-color", "-c"}, `{"Color":true,"Debug":null,"Cache":null}`, "", false},
+		{0, []string{"--color=false", "-d=0", "--cache=true"}, `{"Color":false,"Debug":false,"Cache":true}`, "", false},
+		{0, []string{"--color=1", "--no-cache=false"}, `{"Color":true,"Debug":null,"Cache":true}`, "", false},
+		{0, []string{"--no-color=true"}, `{"Color":false,"Debug":null,"Cache":null}`, "", false},
+		{0, []string{"--no-d", "--no-bogus"}, `{"Color":null,"Debug":null,"Cache":null}`, "--no-bogus --no-d", false},
+		{ParseOptSingleDashAsOpt, []string{"-no-color"}, `{"Color":false,"Debug":null,"Cache":null}`, "", false},
+		{ParseOptAbbrev, []string{"--no-col"}, `{"Color":false,"Debug":null,"Cache":null}`, "", false},
+		{0, []string{"--color=maybe"}, `{"Color":null,"Debug":null,"Cache":null}`, "", true},
+	}
+
+	for _, tst := range tests {
+		var opt TestBoolOptions
+
+		_, b, u, err := parseArgsToStruct(tst.mode, tst.test, &opt)
+		if b1, _ := json.Marshal(b); tst.opts != string(b1) {
+			t.Error("Args:", tst.test, "Expected:", tst.opts, "Found:", string(b1))
+		}
+		var unhand []string
+		for k := range u {
+			unhand = append(unhand, k)
+		}
+		sort.Strings(unhand)
+		if tst.unhand != strings.Join(unhand, " ") {
+			t.Error("Args:", tst.test, "Expected:", tst.unhand, "Found:", unhand)
+		}
+		if tst.err != (err != nil) {
+			t.Error("Args:", tst.test, "Expected:", tst.err, "Found:", err)
+		}
+	}
+
+	r := new(Router)
+	r.Options(&TestBoolOptions{})
+	if help := r.Help(); !strings.Contains(help, "-c, --[no-]color") || !strings.Contains(help, "--[no-]cache") {
+		t.Error("Expected negatable flags in help, Found:", help)
+	}
+}
+
+type testColor int
+
+func (c *testColor) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "red":
+		*c = 1
+	case "blue":
+		*c = 2
+	default:
+		return fmt.Errorf("unknown color %q", b)
+	}
+	return nil
+}
+
+type testUpper string
+
+func (u *testUpper) String() string     { return string(*u) }
+func (u *testUpper) Set(s string) error { *u = testUpper(strings.ToUpper(s)); return nil }
+
+type testHost struct{ Name, Port string }
+
+type TestCustomOptions struct {
+	Color testColor   `cmdln:"-c,--color"`
+	Shade *testColor  `cmdln:"-s,--shade"`
+	Name  *testUpper  `cmdln:"-n,--name"`
+	Addr  net.IP      `cmdln:"-a,--addr"`
+	Peers []net.IP    `cmdln:"-p,--peer" cmdsep:","`
+	Link  *url.URL    `cmdln:"-l,--link"`
+	Host  testHost    `cmdln:"-h,--host"`
+	Hosts []*testHost `cmdln:"-H"`
+}
+
+func TestParseCustomOptions(t *testing.T) {
+	RegisterType(reflect.TypeOf(new(url.URL)), func(s string) (interface{}, error) { return url.Parse(s) })
+	RegisterType(reflect.TypeOf(testHost{}), func(s string) (interface{}, error) {
+		h, p, err := net.SplitHostPort(s)
+		return testHost{h, p}, err
+	})
+
+	tests := []struct {
+		test []string
+		opts string
+		err  bool
+	}{
+		{[]string{}, `{"Color":0,"Shade":null,"Name":null,"Addr":"","Peers":null,"Link":null,"Host":{"Name":"","Port":""},"Hosts":null}`, false},
+		{[]string{"-c", "blue", "--shade=red"}, `{"Color":2,"Shade":1,"Name":null,"Addr":"","Peers":null,"Link":null,"Host":{"Name":"","Port":""},"Hosts":null}`, false},
+		{[]string{"-n", "abc", "-a", "10.0.0.1"}, `{"Color":0,"Shade":null,"Name":"ABC","Addr":"10.0.0.1","Peers":null,"Link":null,"Host":{"Name":"","Port":""},"Hosts":null}`, false},
+		{[]string{"-p", "10.0.0.1,::1", "-p", "10.0.0.2"}, `{"Color":0,"Shade":null,"Name":null,"Addr":"","Peers":["10.0.0.1","::1","10.0.0.2"],"Link":null,"Host":{"Name":"","Port":""},"Hosts":null}`, false},
+		{[]string{"-h", "example.com:80", "-H", "a:1", "-H", "b:2"}, `{"Color":0,"Shade":null,"Name":null,"Addr":"","Peers":null,"Link":null,"Host":{"Name":"example.com","Port":"80"},"Hosts":[{"Name":"a","Port":"1"},{"Name":"b","Port":"2"}]}`, false},
+		{[]string{"-c", "green"}, `{"Color":0,"Shade":null,"Name":null,"Addr":"","Peers":null,"Link":null,"Host":{"Name":"","Port":""},"Hosts":null}`, true},
+		{[]string{"-a", "10.0.0"}, `{"Color":0,"Shade":null,"Name":null,"Addr":"","Peers":null,"Link":null,"Host":{"Name":"","Port":""},"Hosts":null}`, true},
+		{[]string{"-h", "example.com"}, `{"Color":0,"Shade":null,"Name":null,"Addr":"","Peers":null,"Link":null,"Host":{"Name":"","Port":""},"Hosts":null}`, true},
+	}
+
+	for _, tst := range tests {
+		var opt TestCustomOptions
+
+		_, b, _, err := parseArgsToStruct(0, tst.test, &opt)
+		if b1, _ := json.Marshal(b); tst.opts != string(b1) {
+			t.Error("Args:", tst.test, "Expected:", tst.opts, "Found:", string(b1))
+		}
+		if tst.err != (err != nil) {
+			t.Error("Args:", tst.test, "Expected:", tst.err, "Found:", err)
+		}
+	}
+
+	var opt TestCustomOptions
+	if _, _, _, err := parseArgsToStruct(0, []string{"-l", "https://example.com/x?y=1"}, &opt); err != nil || opt.Link == nil || opt.Link.Host != "example.com" {
+		t.Error("Expected: example.com Found:", opt.Link, err)
+	}
+	var cerr *ConversionError
+	if _, _, _, err := parseArgsToStruct(0, []string{"-c", "green"}, &opt); !errors.As(err, &cerr) || cerr.Value != "green" {
+		t.Error("Expected: ConversionError Found:", err)
+	}
+}
+
+type TestRichOptions struct {
+	Timeout *time.Duration  `cmdln:"-t,--timeout,How long to wait"`
+	Size    *ByteSize       `cmdln:"-s,--size"`
+	Since   *time.Time      `cmdln:"-,--since" cmdlayout:"2006-01-02"`
+	At      *time.Time      `cmdln:"-,--at"`
+	Mode    *os.FileMode    `cmdln:"-m,--mode"`
+	Small   *int8           `cmdln:"-,--small"`
+	Count   *uint           `cmdln:"-c,--count"`
+	Big     *int64          `cmdln:"-,--big"`
+	Ratio   *float32        `cmdln:"-,--ratio"`
+	Waits   []time.Duration `cmdln:"-w"`
+}
+
+func TestParseRichOptions(t *testing.T) {
+
+	tests := []struct {
+		test []string
+		opts string
+		err  bool
+	}{
+		{[]string{"-t", "1m30s", "-s", "512MiB"}, `{"Timeout":90000000000,"Size":536870912}`, false},
+		{[]string{"-s", "10GB"}, `{"Size":10000000000}`, false},
+		{[]string{"-s", "1.5k"}, `{"Size":1500}`, false},
+		{[]string{"-s", "2 KiB"}, `{"Size":2048}`, false},
+		{[]string{"-s", "1024"}, `{"Size":1024}`, false},
+		{[]string{"--since", "2024-02-29", "--at", "2024-02-29T10:00:00Z"}, `{"Since":"2024-02-29T00:00:00Z","At":"2024-02-29T10:00:00Z"}`, false},
+		{[]string{"-m", "0644"}, `{"Mode":420}`, false},
+		{[]string{"--small", "-128", "-c", "7", "--big", "9223372036854775807"}, `{"Small":-128,"Count":7,"Big":9223372036854775807}`, false},
+		{[]string{"--ratio", "0.5", "-w", "1s", "-w", "2s"}, `{"Ratio":0.5,"Waits":[1000000000,2000000000]}`, false},
+		{[]string{"-t", "soon"}, `{}`, true},
+		{[]string{"-s", "10XB"}, `{}`, true},
+		{[]string{"-s", "-1"}, `{}`, true},
+		{[]string{"-s", "9000PiB"}, `{}`, true},
+		{[]string{"--since", "02/29/2024"}, `{}`, true},
+		{[]string{"-m", "0944"}, `{}`, true},
+		{[]string{"--small", "128"}, `{}`, true},
+		{[]string{"-c", "-1"}, `{}`, true},
+		{[]string{"--big", "9223372036854775808"}, `{}`, true},
+	}
+
+	for _, tst := range tests {
+		var opt TestRichOptions
+
+		_, _, _, err := parseArgsToStruct(0, tst.test, &opt)
+		b1, _ := json.Marshal(opt)
+		var found map[string]interface{}
+		json.Unmarshal(b1, &found)
+		for k, v := range found {
+			if v == nil {
+				delete(found, k)
+			}
+		}
+		b2, _ := json.Marshal(found)
+		var expected map[string]interface{}
+		json.Unmarshal([]byte(tst.opts), &expected)
+		b3, _ := json.Marshal(expected)
+		if string(b3) != string(b2) {
+			t.Error("Args:", tst.test, "Expected:", string(b3), "Found:", string(b2))
+		}
+		if tst.err != (err != nil) {
+			t.Error("Args:", tst.test, "Expected:", tst.err, "Found:", err)
+		}
+	}
+
+	var opt TestRichOptions
+	_, _, _, err := parseArgsToStruct(0, []string{"--small", "1000"}, &opt)
+	var errs ParseErrors
+	if !errors.As(err, &errs) || !errors.Is(err, strconv.ErrRange) {
+		t.Error("Expected: a range error in ParseErrors Found:", err)
+	}
+
+	r := new(Router)
+	r.Options(&TestRichOptions{})
+	help := r.Help()
+	for _, s := range []string{"How long to wait (format: 1h30m)", "(format: 2006-01-02)", "(format: 512MiB or 10GB)", "(format: 0644)", "(format: " + time.RFC3339 + ")"} {
+		if !strings.Contains(help, s) {
+			t.Error("Expected:", s, "Found:", help)
+		}
+	}
+
+	if s := ByteSize(10e9).String(); s != "10GB" {
+		t.Error("Expected: 10GB Found:", s)
+	}
+	if s := ByteSize(512 << 20).String(); s != "512MiB" {
+		t.Error("Expected: 512MiB Found:", s)
+	}
+}
+
+type TestRichCommand struct {
+	Name  *string
+	Port  *uint16
+	Delay *time.Duration
+}
+
+func TestParseRichCommand(t *testing.T) {
+	var cmd TestRichCommand
+	if err := parseCmds(map[string]string{"name": "web", "port": "8080", "delay": "5s"}, &cmd); err != nil {
+		t.Fatal(err)
+	}
+	if *cmd.Name != "web" || *cmd.Port != 8080 || *cmd.Delay != 5*time.Second {
+		t.Error("Expected: web 8080 5s Found:", *cmd.Name, *cmd.Port, *cmd.Delay)
+	}
+
+	err := parseCmds(map[string]string{"port": "70000"}, &cmd)
+	var errs ParseErrors
+	var cerr *ConversionError
+	if !errors.As(err, &errs) || !errors.As(err, &cerr) || cerr.Option != "port" || !errors.Is(err, strconv.ErrRange) {
+		t.Error("Expected: a range error for port Found:", err)
+	}
+}
+
+type TestDefaultOptions struct {
+	Timeout *time.Duration    `cmdln:"-t,--timeout,How long to wait" default:"30s"`
+	Name    *string           `cmdln:"-n,--name" default:"world"`
+	Color   *bool             `cmdln:"-c,--color" default:"true"`
+	Verbose Count             `cmdln:"-v" default:"1"`
+	Include []string          `cmdln:"-I" cmdsep:":" default:"/usr:/opt"`
+	Define  map[string]string `cmdln:"-D" default:"env=dev"`
+	Level   *int              `cmdln:"-l"`
+}
+
+func TestParseDefaultOptions(t *testing.T) {
+
+	tests := []struct {
+		test []string
+		opts string
+		err  bool
+	}{
+		{[]string{}, `{"Timeout":30000000000,"Name":"world","Color":true,"Verbose":1,"Include":["/usr","/opt"],"Define":{"env":"dev"},"Level":null}`, false},
+		{[]string{"-t", "1m", "-n", "x", "--no-color", "-vv", "-I", "/a", "-D", "a=b", "-l", "2"}, `{"Timeout":60000000000,"Name":"x","Color":false,"Verbose":2,"Include":["/a"],"Define":{"a":"b"},"Level":2}`, false},
+		{[]string{"-t", "never"}, `{"Timeout":null,"Name":"world","Color":true,"Verbose":1,"Include":["/usr","/opt"],"Define":{"env":"dev"},"Level":null}`, true},
+	}
+
+	for _, tst := range tests {
+		var opt TestDefaultOptions
+
+		_, b, _, err := parseArgsToStruct(0, tst.test, &opt)
+		if b1, _ := json.Marshal(b); tst.opts != string(b1) {
+			t.Error("Args:", tst.test, "Expected:", tst.opts, "Found:", string(b1))
+		}
+		if tst.err != (err != nil) {
+			t.Error("Args:", tst.test, "Expected:", tst.err, "Found:", err)
+		}
+	}
+
+	// The default does not add to what was there before.
+	opt := TestDefaultOptions{Include: []string{"/x"}, Define: map[string]string{"a": "b"}}
+	parseArgsToStruct(0, nil, &opt)
+	if strings.Join(opt.Include, ":") != "/usr:/opt" || len(opt.Define) != 1 {
+		t.Error("Expected: the defaults Found:", opt.Include, opt.Define)
+	}
+
+	var bad struct {
+		Size *int `cmdln:"-s" default:"big"`
+	}
+	_, _, _, err := parseArgsToStruct(0, nil, &bad)
+	var cerr *ConfigError
+	if !errors.As(err, &cerr) || cerr.Field != "Size" {
+		t.Error("Expected: ConfigError for Size Found:", err)
+	}
+
+	r := new(Router)
+	r.Options(&TestDefaultOptions{})
+	help := r.Help()
+	for _, s := range []string{"How long to wait (format: 1h30m) (default: 30s)", "(default: world)", "(default: true)", "(default: /usr:/opt)"} {
+		if !strings.Contains(help, s) {
+			t.Error("Expected:", s, "Found:", help)
+		}
+	}
+}
+
+type TestRequiredOptions struct {
+	Config *string `cmdln:"-c,--config,The config file" required:"true"`
+	Name   *string `cmdln:"-n" required:"true"`
+	Region *string `cmdln:"-r,--region" required:"true" default:"eu"`
+	Force  *bool   `cmdln:"-f,--force"`
+}
+
+func TestRequiredAndArity(t *testing.T) {
+
+	newRouter := func(found *[]string) *Router {
+		r := new(Router)
+		r.Options(&TestRequiredOptions{})
+		r.HandleE("copy", func(c *Context) error { *found = c.Args(); return nil })
+		r.Arity("copy", 2, 2)
+		r.HandleE("rm", func(c *Context) error { *found = c.Args(); return nil })
+		r.Arity("rm", 1, -1)
+		r.HandleE("rm all", func(c *Context) error { *found = []string{"all"}; return nil })
+		r.HandleE("ls :dir:", func(c *Context) error { *found = c.Args(); return nil })
+		r.Arity("ls :dir:", 0, 1)
+		r.HandleE("stat", func(c *Context) error { *found = c.Args(); return nil })
+		return r
+	}
+
+	tests := []struct {
+		test  []string
+		found string
+		opts  string
+		args  string
+	}{
+		{[]string{"-c", "x", "-n", "y", "copy", "a", "b"}, "a b", "", ""},
+		{[]string{"-c", "x", "-n", "y", "copy", "a", "-f", "b"}, "a b", "", ""},
+		{[]string{"-c", "x", "-n", "y", "rm", "a", "b", "c"}, "a b c", "", ""},
+		{[]string{"-c", "x", "-n", "y", "rm", "all"}, "all", "", ""},
+		{[]string{"-c", "x", "-n", "y", "ls"}, "", "", ""},
+		{[]string{"-c", "x", "-n", "y", "ls", "dir", "more"}, "more", "", ""},
+		{[]string{"-c", "x", "-n", "y", "stat"}, "", "", ""},
+		{[]string{"copy", "a", "b"}, "", "--config, -n", ""},
+		{[]string{"-n", "y", "copy", "a"}, "", "--config", "takes 2 arguments, got 1"},
+		{[]string{"-c", "x", "-n", "y", "copy", "a", "b", "c"}, "", "", "takes 2 arguments, got 3"},
+		{[]string{"-c", "x", "-n", "y", "rm"}, "", "", "takes at least 1 arguments, got 0"},
+		{[]string{"-c", "x", "-n", "y", "ls", "dir", "a", "b"}, "", "", "takes 0 to 1 arguments, got 2"},
+	}
+
+	for _, tst := range tests {
+		var found []string
+		err := Run(tst.test, newRouter(&found))
+
+		var missing *MissingError
+		if tst.opts == "" && tst.args == "" {
+			if err != nil || strings.Join(found, " ") != tst.found {
+				t.Error("Args:", tst.test, "Expected:", tst.found, "Found:", found, err)
+			}
+			continue
+		}
+		if !errors.As(err, &missing) {
+			t.Error("Args:", tst.test, "Expected: *MissingError Found:", err)
+			continue
+		}
+		if strings.Join(missing.Options, ", ") != tst.opts || missing.Arguments != tst.args {
+			t.Error("Args:", tst.test, "Expected:", tst.opts, tst.args, "Found:", missing.Options, missing.Arguments)
+		}
+		if !strings.HasPrefix(missing.Usage, "Usage: ") || !strings.Contains(err.Error(), missing.Usage) {
+			t.Error("Args:", tst.test, "Expected: the usage line Found:", err)
+		}
+		if found != nil {
+			t.Error("Args:", tst.test, "Expected: the handler not to run")
+		}
+	}
+
+	if err := Run([]string{"stat", "extra"}, newRouter(new([]string))); err == nil {
+		t.Error("Expected: *NotFoundError Found:", err)
+	}
+
+	// A router without options.
+	r := new(Router)
+	r.Handle("cp", func(c *Context) {})
+	r.Arity("cp", 2, 2)
+	if help := r.Help(); !strings.HasPrefix(help, "Usage: ") {
+		t.Error("Expected: the help Found:", help)
+	}
+	var missing *MissingError
+	if err := Run([]string{"cp", "a"}, r); !errors.As(err, &missing) || missing.Arguments != "takes 2 arguments, got 1" {
+		t.Error("Expected: *MissingError Found:", err)
+	}
+	if _, err := structOf(nil); err == nil {
+		t.Error("Expected: *ConfigError Found:", err)
+	}
+	if code := DefaultExitCodes.Code(&MissingError{}); code != 2 {
+		t.Error("Expected: 2 Found:", code)
+	}
+
+	r = new(Router)
+	r.Options(&TestRequiredOptions{})
+	if help := r.Help(); !strings.Contains(help, "The config file (required)") {
+		t.Error("Expected: (required) Found:", help)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected: a panic for an arity before the command line")
+		}
+	}()
+	r.Arity("bogus", 1, 1)
+}
+
+type TestValidateOptions struct {
+	Format  *string        `cmdln:"-f,--format,Output format" choices:"json,yaml,table"`
+	Port    *int           `cmdln:"-p,--port" min:"1" max:"65535"`
+	Name    *string        `cmdln:"-n,--name" pattern:"^[a-z-]+$"`
+	Timeout *time.Duration `cmdln:"-t" min:"1s"`
+	Size    *ByteSize      `cmdln:"-s" max:"1GiB"`
+	Tags    []string       `cmdln:"-T" cmdsep:"," choices:"a,b,c"`
+	Limits  map[string]int `cmdln:"-L" min:"0"`
+	Ratio   *float64       `cmdln:"-r" min:"0" max:"1"`
+}
+
+func TestParseValidateOptions(t *testing.T) {
+
+	tests := []struct {
+		test  []string
+		value string
+		rule  string
+	}{
+		{[]string{"-f", "json", "-p", "1", "-n", "my-app", "-t", "1s", "-s", "1GiB", "-T", "a,c", "-L", "x=0", "-r", "1"}, "", ""},
+		{[]string{"-p", "65535", "-r", "0"}, "", ""},
+		{[]string{"-f", "xml"}, "xml", "choices"},
+		{[]string{"-p", "0"}, "0", "min"},
+		{[]string{"--port=65536"}, "65536", "max"},
+		{[]string{"-n", "My_App"}, "My_App", "pattern"},
+		{[]string{"-t", "500ms"}, "500ms", "min"},
+		{[]string{"-s", "2GB"}, "2GB", "max"},
+		{[]string{"-T", "a,d"}, "d", "choices"},
+		{[]string{"-L", "x=-1"}, "-1", "min"},
+		{[]string{"-r", "1.5"}, "1.5", "max"},
+	}
+
+	for _, tst := range tests {
+		var opt TestValidateOptions
+
+		_, _, _, err := parseArgsToStruct(0, tst.test, &opt)
+		var verr *ValidationError
+		if tst.rule == "" {
+			if err != nil {
+				t.Error("Args:", tst.test, "Expected: nil Found:", err)
+			}
+			continue
+		}
+		if !errors.As(err, &verr) || verr.Value != tst.value || verr.Rule != tst.rule || verr.Option != tst.test[0] && !strings.HasPrefix(tst.test[0], verr.Option+"=") {
+			t.Error("Args:", tst.test, "Expected:", tst.value, tst.rule, "Found:", err)
+		}
+	}
+
+	var opt TestValidateOptions
+	_, _, _, err := parseArgsToStruct(0, []string{"-f", "xml"}, &opt)
+	if s := fmt.Sprint(err); !strings.Contains(s, "must be one of json, yaml, table") {
+		t.Error("Expected: the choices in the error Found:", s)
+	}
+	if code := DefaultExitCodes.Code(err); code != 2 {
+		t.Error("Expected: 2 Found:", code)
+	}
+
+	var bad struct {
+		Name *string `cmdln:"-n" min:"a"`
+		Code *string `cmdln:"-c" pattern:"("`
+	}
+	_, _, _, err = parseArgsToStruct(0, []string{"-n", "x", "-c", "y"}, &bad)
+	var errs ParseErrors
+	var cerr *ConfigError
+	if !errors.As(err, &errs) || len(errs) != 2 || !errors.As(errs[0], &cerr) || !errors.As(errs[1], &cerr) {
+		t.Error("Expected: 2 ConfigErrors Found:", err)
+	}
+
+	r := new(Router)
+	r.Options(&TestValidateOptions{})
+	if help := r.Help(); !strings.Contains(help, "Output format (choices: json, yaml, table)") {
+		t.Error("Expected: the choices in help Found:", help)
+	}
+	if c := r.Choices("--format"); strings.Join(c, " ") != "json yaml table" {
+		t.Error("Expected: json yaml table Found:", c)
+	}
+	if c := r.SubCmd("sub").Router.Choices("-T"); c != nil {
+		t.Error("Expected: nil Found:", c)
+	}
+	if c := r.Choices("-T"); strings.Join(c, " ") != "a b c" {
+		t.Error("Expected: a b c Found:", c)
+	}
+	if c := r.Choices("-p"); c != nil {
+		t.Error("Expected: nil Found:", c)
+	}
+}
+
+type TestGroupOptions struct {
+	JSON *bool   `cmdln:"-,--json" exclusive:"output"`
+	YAML *bool   `cmdln:"-,--yaml" exclusive:"output"`
+	Src  *string `cmdln:"-s,--src" atleastone:"input"`
+	URL  *string `cmdln:"-u" atleastone:"input"`
+	Cert *string `cmdln:"-,--cert" requires:"--key"`
+	Key  *string `cmdln:"-k,--key"`
+	Fast *bool   `cmdln:"-f" conflicts:"--safe,-k"`
+	Safe *bool   `cmdln:"-,--safe"`
+}
+
+func TestParseGroupOptions(t *testing.T) {
+
+	tests := []struct {
+		test []string
+		errs []string
+	}{
+		{[]string{"-s", "x"}, nil},
+		{[]string{"-u", "x", "--json", "--cert", "c", "-k", "k"}, nil},
+		{[]string{"-s", "x", "-f"}, nil},
+		{[]string{}, nil},
+		{[]string{"-s", "x", "--json", "--yaml"}, []string{"exclusive --json --yaml"}},
+		{[]string{"-s", "x", "--json", "--no-yaml"}, []string{"exclusive --json --yaml"}},
+		{[]string{"-s", "x", "--cert", "c"}, []string{"requires --cert --key"}},
+		{[]string{"-s", "x", "-f", "--safe", "-k", "k"}, []string{"conflicts -f --safe --key"}},
+		{[]string{"--json", "--yaml", "-f", "--safe"}, []string{"exclusive --json --yaml", "conflicts -f --safe"}},
+	}
+
+	for _, tst := range tests {
+		var opt TestGroupOptions
+
+		_, _, _, err := parseArgsToStruct(0, tst.test, &opt)
+		var found []string
+		var errs ParseErrors
+		errors.As(err, &errs)
+		for _, e := range errs {
+			var gerr *GroupError
+			if !errors.As(e, &gerr) {
+				t.Error("Args:", tst.test, "Expected: *GroupError Found:", e)
+				continue
+			}
+			found = append(found, gerr.Rule+" "+strings.Join(gerr.Options, " "))
+		}
+		if strings.Join(tst.errs, "; ") != strings.Join(found, "; ") {
+			t.Error("Args:", tst.test, "Expected:", tst.errs, "Found:", found)
+		}
+	}
+
+	var opt TestGroupOptions
+	_, _, _, err := parseArgsToStruct(0, []string{"-s", "x", "--cert", "c"}, &opt)
+	if s := fmt.Sprint(err); !strings.Contains(s, "the option --cert needs: --key") {
+		t.Error("Expected: a clear error Found:", s)
+	}
+
+	r := new(Router)
+	r.Options(&TestGroupOptions{})
+	r.HandleE("run", func(c *Context) error { return nil })
+	sr := r.SubCmd("other")
+	sr.HandleE("run", func(c *Context) error { return nil })
+	sr.Options(&struct {
+		A *string `cmdln:"-a" atleastone:"x"`
+		B *string `cmdln:"-b" atleastone:"x"`
+	}{})
+	var missing *MissingError
+	if err := Run([]string{"run"}, r); !errors.As(err, &missing) || strings.Join(missing.Options, ", ") != "--src or -u" {
+		t.Error("Expected: --src or -u Found:", err)
+	}
+	if err := Run([]string{"-u", "x", "run"}, r); err != nil {
+		t.Error("Expected: nil Found:", err)
+	}
+	if err := Run([]string{"-u", "x", "other", "run"}, r); !errors.As(err, &missing) || strings.Join(missing.Options, ", ") != "-a or -b" {
+		t.Error("Expected: -a or -b Found:", err)
+	}
+
+	var bad struct {
+		A *bool `cmdln:"-a" requires:"-b"`
+	}
+	var cerr *ConfigError
+	if _, _, _, err := parseArgsToStruct(0, nil, &bad); !errors.As(err, &cerr) {
+		t.Error("Expected: *ConfigError Found:", err)
+	}
+
+	r = new(Router)
+	r.Options(&TestGroupOptions{})
+	help := r.Help()
+	for _, s := range []string{"Groups:\n  only one of: --json, --yaml\n  at least one of: --src, -u\n  --cert requires: --key\n  -f conflicts with: --safe, --key\n"} {
+		if !strings.HasSuffix(help, s) {
+			t.Error("Expected:", s, "Found:", help)
+		}
+	}
+}
+
+type TestEnvOptions struct {
+	Token   *string        `cmdln:"-t,--token,The API token" env:"TEST_APP_TOKEN"`
+	DryRun  *bool          `cmdln:"-,--dry-run"`
+	Timeout *time.Duration `cmdln:"-,--timeout" default:"30s"`
+	Tags    []string       `cmdln:"-T,--tag" cmdsep:","`
+	Level   *int           `cmdln:"-l,--level" max:"3"`
+	Secret  *string        `cmdln:"-,--secret" env:"-"`
+	Short   *string        `cmdln:"-s"`
+}
+
+func TestEnvFallback(t *testing.T) {
+	t.Setenv("TEST_APP_TOKEN", "abc")
+	t.Setenv("APP_DRY_RUN", "true")
+	t.Setenv("APP_TIMEOUT", "1m")
+	t.Setenv("APP_TAG", "a,b")
+	t.Setenv("APP_SECRET", "leak")
+	t.Setenv("APP_S", "x")
+
+	tests := []struct {
+		test []string
+		opts string
+	}{
+		{[]string{"run"}, `{"Token":"abc","DryRun":true,"Timeout":60000000000,"Tags":["a","b"],"Level":null,"Secret":null,"Short":null}`},
+		{[]string{"-t", "xyz", "--no-dry-run", "--timeout", "5s", "-T", "c", "run"}, `{"Token":"xyz","DryRun":false,"Timeout":5000000000,"Tags":["c"],"Level":null,"Secret":null,"Short":null}`},
+	}
+
+	for _, tst := range tests {
+		var opt TestEnvOptions
+
+		r := new(Router)
+		r.EnvPrefix("APP")
+		r.Options(&opt)
+		r.HandleE("run", func(c *Context) error { return nil })
+		if err := Run(tst.test, r); err != nil {
+			t.Error("Args:", tst.test, "Expected: nil Found:", err)
+		}
+		if b1, _ := json.Marshal(opt); tst.opts != string(b1) {
+			t.Error("Args:", tst.test, "Expected:", tst.opts, "Found:", string(b1))
+		}
+	}
+
+	// Without a prefix only the env tags are used, and a subrouter
+	// uses the prefix of its parent.
+	var opt TestEnvOptions
+	r := new(Router)
+	r.Options(&opt)
+	r.HandleE("run", func(c *Context) error { return nil })
+	Run([]string{"run"}, r)
+	if b1, _ := json.Marshal(opt); string(b1) != `{"Token":"abc","DryRun":null,"Timeout":30000000000,"Tags":null,"Level":null,"Secret":null,"Short":null}` {
+		t.Error("Expected: only the token Found:", string(b1))
+	}
+
+	var sub TestEnvOptions
+	r = new(Router)
+	r.EnvPrefix("APP_")
+	sr := r.SubCmd("sub")
+	sr.Options(&sub)
+	sr.HandleE("run", func(c *Context) error { return nil })
+	Run([]string{"sub", "run"}, r)
+	if sub.DryRun == nil || !*sub.DryRun {
+		t.Error("Expected: true Found:", sub.DryRun)
+	}
+
+	t.Setenv("APP_LEVEL", "9")
+	t.Setenv("APP_TIMEOUT", "soon")
+	r = new(Router)
+	r.EnvPrefix("APP")
+	r.Options(&TestEnvOptions{})
+	r.HandleE("run", func(c *Context) error { return nil })
+	err := Run([]string{"run"}, r)
+	var cerr *ConversionError
+	var verr *ValidationError
+	if !errors.As(err, &cerr) || cerr.Option != "$APP_TIMEOUT" || !errors.As(err, &verr) || verr.Option != "$APP_LEVEL" {
+		t.Error("Expected: errors for $APP_TIMEOUT and $APP_LEVEL Found:", err)
+	}
+	if err := Run([]string{"--level", "1", "--timeout", "1s", "run"}, r); err != nil {
+		t.Error("Expected: nil Found:", err)
+	}
+
+	if help := r.Help(); !strings.Contains(help, "The API token (env: TEST_APP_TOKEN)") || !strings.Contains(help, "(env: APP_DRY_RUN)") || strings.Contains(help, "APP_SECRET") || strings.Contains(help, "APP_S)") {
+		t.Error("Expected: the env vars in help Found:", help)
+	}
+}
+
+type TestConfigOptions struct {
+	Timeout *time.Duration    `cmdln:"-t,--timeout" default:"30s"`
+	Token   *string           `cmdln:"-,--token" env:"TEST_CFG_TOKEN"`
+	Name    *string           `cmdln:"-n,--name"`
+	Debug   *bool             `cmdln:"-d,--debug"`
+	Include []string          `cmdln:"-I,--include"`
+	Labels  map[string]string `cmdln:"-L,--label"`
+	Level   *int              `cmdln:"-l,--level" max:"3"`
+	Short   *string           `cmdln:"-s"`
+}
+
+func TestConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("XDG_CONFIG_DIRS", filepath.Join(dir, "none"))
+	t.Setenv("TEST_CFG_TOKEN", "")
+
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	ini := write("app/config.ini", "# comment\ntimeout = 5s\ntoken=file\n; more\nname = \"a b\"\ndebug = true\ninclude = /a\ninclude = /b\nlabel = env=dev\n")
+	jsn := write("app.json", `{"timeout": "1m", "name": "json", "debug": false, "include": ["/x", "/y"], "label": {"b": "2", "a": 1}, "level": 2, "token": null}`)
+
+	run := func(args ...string) (TestConfigOptions, error) {
+		var opt TestConfigOptions
+		r := new(Router)
+		r.ConfigFile("app/config.ini")
+		r.Options(&opt)
+		r.HandleE("run", func(c *Context) error { return nil })
+		err := Run(append(args, "run"), r)
+		return opt, err
+	}
+
+	tests := []struct {
+		test []string
+		env  string
+		opts string
+	}{
+		{nil, "", `{"Timeout":5000000000,"Token":"file","Name":"a b","Debug":true,"Include":["/a","/b"],"Labels":{"env":"dev"},"Level":null,"Short":null}`},
+		{nil, "env", `{"Timeout":5000000000,"Token":"env","Name":"a b","Debug":true,"Include":["/a","/b"],"Labels":{"env":"dev"},"Level":null,"Short":null}`},
+		{[]string{"--token", "flag", "-t", "1s", "--no-debug", "-I", "/c"}, "env", `{"Timeout":1000000000,"Token":"flag","Name":"a b","Debug":false,"Include":["/c"],"Labels":{"env":"dev"},"Level":null,"Short":null}`},
+		{[]string{"--config", jsn}, "", `{"Timeout":60000000000,"Token":null,"Name":"json","Debug":false,"Include":["/x","/y"],"Labels":{"a":"1","b":"2"},"Level":2,"Short":null}`},
+		{[]string{"--config=" + filepath.Join(dir, "none.ini")}, "", ``},
+	}
+
+	for _, tst := range tests {
+		t.Setenv("TEST_CFG_TOKEN", tst.env)
+		opt, err := run(tst.test...)
+		var ferr *FileError
+		if tst.opts == "" {
+			if !errors.As(err, &ferr) || !errors.Is(err, os.ErrNotExist) {
+				t.Error("Args:", tst.test, "Expected: *FileError Found:", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error("Args:", tst.test, "Expected: nil Found:", err)
+		}
+		if b1, _ := json.Marshal(opt); tst.opts != string(b1) {
+			t.Error("Args:", tst.test, "Expected:", tst.opts, "Found:", string(b1))
+		}
+	}
+	t.Setenv("TEST_CFG_TOKEN", "")
+
+	// Without a file the defaults are used.
+	os.Remove(ini)
+	if opt, err := run(); err != nil || *opt.Timeout != 30*time.Second || opt.Name != nil {
+		t.Error("Expected: the defaults Found:", opt, err)
+	}
+
+	bad := []struct {
+		data string
+		key  string
+		line int
+	}{
+		{"bogus = 1\n", "bogus", 1},
+		{"name = a\n\nname = b\n", "name", 3},
+		{"s = x\n", "s", 1},
+		{"name\n", "", 1},
+		{"level = 9\n", "", 0},
+	}
+	for _, tst := range bad {
+		write("app/config.ini", tst.data)
+		_, err := run()
+		var ferr *FileError
+		var verr *ValidationError
+		switch {
+		case tst.line == 0:
+			if !errors.As(err, &verr) || verr.Option != ini+":1" {
+				t.Error("Data:", tst.data, "Expected: *ValidationError Found:", err)
+			}
+		case !errors.As(err, &ferr) || ferr.Key != tst.key || ferr.Line != tst.line || ferr.Path != ini:
+			t.Error("Data:", tst.data, "Expected:", tst.key, tst.line, "Found:", err)
+		}
+	}
+
+	write("app.json", `{"name": "a", "name": "b"}`)
+	if _, err := run("--config", jsn); err == nil || !strings.Contains(err.Error(), "name: the option is set more than once") {
+		t.Error("Expected: a *FileError for name Found:", err)
+	}
+	write("app.json", `["name"]`)
+	if _, err := run("--config", jsn); err == nil {
+		t.Error("Expected: a *FileError Found:", err)
+	}
+
+	r := new(Router)
+	r.ConfigFile("app/config.ini")
+	r.Options(&TestConfigOptions{})
+	if help := r.Help(); !strings.Contains(help, "--config path") {
+		t.Error("Expected: --config in help Found:", help)
+	}
+
+	// An options struct with its own --config, and a short name for it.
+	var own struct {
+		Config *string `cmdln:"-c,--config"`
+		Name   *string `cmdln:"-n,--name"`
+	}
+	other := write("other.ini", "name = other\n")
+	for _, args := range [][]string{{"-c", other, "run"}, {"--config", other, "run"}, {"--config=" + other, "run"}} {
+		own.Config, own.Name = nil, nil
+		r := new(Router)
+		r.ConfigFile("app/config.ini")
+		r.Options(&own)
+		r.HandleE("run", func(c *Context) error { return nil })
+		if err := Run(args, r); err != nil || own.Config == nil || *own.Config != other || own.Name == nil || *own.Name != "other" {
+			t.Error("Args:", args, "Expected: the config file Found:", own.Config, own.Name, err)
+		}
+		if help := r.Help(); strings.Count(help, "--config") != 1 {
+			t.Error("Expected: one --config in help Found:", help)
+		}
+	}
+
+	sr := r.SubCmd("sub")
+	sr.Options(&TestConfigOptions{})
+	if help := sr.Help(); strings.Contains(help, "--config") {
+		t.Error("Expected: no --config in the subrouter help Found:", help)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected: a panic for the config file of a subrouter")
+		}
+	}()
+	sr.ConfigFile("sub.ini")
+}
+
+func TestSources(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("XDG_CONFIG_DIRS", filepath.Join(dir, "none"))
+	t.Setenv("TEST_CFG_TOKEN", "env")
+
+	ini := filepath.Join(dir, "app.ini")
+	if err := os.WriteFile(ini, []byte("name = a\n\ninclude = /a\ninclude = /b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var opt TestConfigOptions
+	var srcs []Source
+	r := new(Router)
+	r.Mode(ParseFailOnUnhandled)
+	r.ConfigFile("app.ini")
+	r.DebugFlag("--debug-options")
+	r.Options(&opt)
+	r.HandleE("run", func(c *Context) error {
+		for _, name := range []string{"timeout", "--token", "Name", "I", "d", "level", "label", "s"} {
+			srcs = append(srcs, c.Source(name))
+		}
+		return nil
+	})
+
+	var buf strings.Builder
+	c := NewContext()
+	c.rawArgs = []string{"-d", "--level=2", "--debug-options", "run"}
+	c.StdErr = &buf
+	if err := r.ServeCmdlnE(c); err != nil {
+		t.Fatal("Expected: nil Found:", err)
+	}
+
+	tests := []struct {
+		from, name, value, str string
+	}{
+		{SourceDefault, "", "30s", "default"},
+		{SourceEnv, "$TEST_CFG_TOKEN", "env", "env $TEST_CFG_TOKEN"},
+		{SourceFile, ini + ":1", "a", "file " + ini + ":1"},
+		{SourceFile, ini + ":3", "/a /b", "file " + ini + ":3"},
+		{SourceFlag, "-d", "true", "flag -d"},
+		{SourceFlag, "--level", "2", "flag --level"},
+		{"", "", "", "unset"},
+		{"", "", "", "unset"},
+	}
+	for i, tst := range tests {
+		s := srcs[i]
+		if s.From != tst.from || s.Name != tst.name || s.Value != tst.value || s.String() != tst.str {
+			t.Error("Field:", s.Field, "Expected:", tst.str, tst.value, "Found:", s, s.Value)
+		}
+	}
+
+	for _, want := range []string{"OPTION", "--timeout   30s", "--include   [/a /b]   file " + ini + ":3", "--label               unset"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected: %q in the table Found:\n%s", want, buf.String())
+		}
+	}
+
+	if help := r.Help(); !strings.Contains(help, "--debug-options") {
+		t.Error("Expected: --debug-options in help Found:", help)
+	}
+
+	// The flag works for the routes of subrouters.
+	sr := r.SubCmd("sub")
+	sr.Options(&TestSliceOptions{})
+	sr.Handle("run", func(c *Context) {})
+	buf.Reset()
+	c = NewContext()
+	c.rawArgs = []string{"sub", "run", "--debug-options", "-p", "1,2"}
+	c.StdErr = &buf
+	if err := r.ServeCmdlnE(c); err != nil || !strings.Contains(buf.String(), "--port      [1 2]     flag -p") {
+		t.Errorf("Expected: the table of the subrouter Found: %v\n%s", err, buf.String())
+	}
+	if help := sr.Help(); strings.Contains(help, "--debug-options") {
+		t.Error("Expected: no --debug-options in the subrouter help Found:", help)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected: a panic for the debug flag of a subrouter")
+		}
+	}()
+	sr.DebugFlag("--debug")
+}
+
+func TestConfigProfiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("XDG_CONFIG_DIRS", filepath.Join(dir, "none"))
+	t.Setenv("TEST_CFG_TOKEN", "")
+	t.Setenv("TEST_PROFILE", "")
+
+	ini := filepath.Join(dir, "app.ini")
+	jsn := filepath.Join(dir, "app.json")
+	os.WriteFile(ini, []byte("timeout = 5s\nname = shared\ninclude = /a\n\n[staging]\nname = staging\ninclude = /s\n[prod]\ntimeout = 1m\n"), 0644)
+	os.WriteFile(jsn, []byte(`{"name": "json", "profiles": {"staging": {"name": "json-staging", "include": ["/j"]}, "dev": {}}}`), 0644)
+
+	var r *Router
+	run := func(def string, args ...string) (opt TestConfigOptions, profile string, src Source, err error) {
+		r = new(Router)
+		r.EnvPrefix("TEST")
+		r.ConfigFile("app.ini")
+		r.Profile(def)
+		r.Options(&opt)
+		r.HandleE("run", func(c *Context) error {
+			profile, src = c.Profile(), c.Source("name")
+			return nil
+		})
+		err = Run(append(args, "run"), r)
+		return
+	}
+
+	tests := []struct {
+		def     string
+		env     string
+		test    []string
+		profile string
+		opts    string
+		src     string
+	}{
+		{"", "", nil, "", `{"Timeout":5000000000,"Token":null,"Name":"shared","Debug":null,"Include":["/a"],"Labels":null,"Level":null,"Short":null}`, "file " + ini + ":2"},
+		{"", "", []string{"--profile", "staging"}, "staging", `{"Timeout":5000000000,"Token":null,"Name":"staging","Debug":null,"Include":["/s"],"Labels":null,"Level":null,"Short":null}`, "file " + ini + ":6"},
+		{"", "prod", nil, "prod", `{"Timeout":60000000000,"Token":null,"Name":"shared","Debug":null,"Include":["/a"],"Labels":null,"Level":null,"Short":null}`, "file " + ini + ":2"},
+		{"qa", "", nil, "", `{"Timeout":5000000000,"Token":null,"Name":"shared","Debug":null,"Include":["/a"],"Labels":null,"Level":null,"Short":null}`, "file " + ini + ":2"},
+		{"prod", "", nil, "prod", `{"Timeout":60000000000,"Token":null,"Name":"shared","Debug":null,"Include":["/a"],"Labels":null,"Level":null,"Short":null}`, "file " + ini + ":2"},
+		{"prod", "prod", []string{"--profile=staging", "-n", "flag"}, "staging", `{"Timeout":5000000000,"Token":null,"Name":"flag","Debug":null,"Include":["/s"],"Labels":null,"Level":null,"Short":null}`, "flag -n"},
+		{"", "", []string{"--config", jsn, "--profile", "staging"}, "staging", `{"Timeout":30000000000,"Token":null,"Name":"json-staging","Debug":null,"Include":["/j"],"Labels":null,"Level":null,"Short":null}`, "file " + jsn},
+		{"", "", []string{"--config", jsn, "--profile", "dev"}, "dev", `{"Timeout":30000000000,"Token":null,"Name":"json","Debug":null,"Include":null,"Labels":null,"Level":null,"Short":null}`, "file " + jsn},
+	}
+
+	for _, tst := range tests {
+		t.Setenv("TEST_PROFILE", tst.env)
+		opt, profile, src, err := run(tst.def, tst.test...)
+		if err != nil {
+			t.Error("Args:", tst.test, "Expected: nil Found:", err)
+			continue
+		}
+		if b1, _ := json.Marshal(opt); tst.opts != string(b1) {
+			t.Error("Args:", tst.test, "Expected:", tst.opts, "Found:", string(b1))
+		}
+		if profile != tst.profile || src.String() != tst.src {
+			t.Error("Args:", tst.test, "Expected:", tst.profile, tst.src, "Found:", profile, src)
+		}
+	}
+	t.Setenv("TEST_PROFILE", "")
+
+	if _, _, _, err := run("prod", "--profile", "staging"); err != nil || !strings.Contains(r.Help(), "--profile name             The profile of the config file (env: TEST_PROFILE) (default: prod)") {
+		t.Error("Expected: the default profile in help Found:", err, r.Help())
+	}
+
+	var help string
+	hr := new(Router)
+	hr.ConfigFile("app.ini")
+	hr.Options(&TestConfigOptions{})
+	hr.Handle("run", func(c *Context) { help = hr.HelpFor(c) })
+	if err := Run([]string{"--profile", "staging", "run"}, hr); err != nil || !strings.Contains(help, "The profile of the config file (active: staging)") {
+		t.Error("Expected: the active profile in help Found:", err, help)
+	}
+
+	// An options struct with its own --profile, and a short name for it.
+	var own struct {
+		Profile *string `cmdln:"-p,--profile"`
+		Name    *string `cmdln:"-n,--name"`
+	}
+	or := new(Router)
+	or.ConfigFile("app.ini")
+	or.Options(&own)
+	or.HandleE("run", func(c *Context) error { return nil })
+	pini := filepath.Join(dir, "p.ini")
+	os.WriteFile(pini, []byte("name = shared\n[staging]\nname = staging\n"), 0644)
+	if err := Run([]string{"-p", "staging", "--config", pini, "run"}, or); err != nil || own.Profile == nil || *own.Profile != "staging" || own.Name == nil || *own.Name != "staging" {
+		t.Error("Expected: the staging profile Found:", own.Profile, own.Name, err)
+	}
+
+	var ferr *FileError
+	if _, _, _, err := run("", "--profile", "nope"); !errors.As(err, &ferr) || ferr.Path != ini || !strings.Contains(err.Error(), `there is no profile "nope"`) {
+		t.Error("Expected: a *FileError for the profile Found:", err)
+	}
+	os.WriteFile(ini, []byte("[]\n"), 0644)
+	if _, _, _, err := run(""); !errors.As(err, &ferr) || ferr.Line != 1 {
+		t.Error("Expected: a *FileError for the section Found:", err)
+	}
+	os.Remove(ini)
+	if _, profile, _, err := run("prod"); err != nil || profile != "" {
+		t.Error("Expected: the default profile to be skipped Found:", profile, err)
+	}
+	if _, _, _, err := run("", "--profile", "prod"); !errors.As(err, &ferr) {
+		t.Error("Expected: a *FileError for the missing file Found:", err)
+	}
+	t.Setenv("TEST_PROFILE", "prod")
+	if _, _, _, err := run(""); !errors.As(err, &ferr) {
+		t.Error("Expected: a *FileError for the missing file Found:", err)
+	}
+
+	sr := r.SubCmd("sub")
+	sr.Options(&TestConfigOptions{})
+	if help := sr.Help(); strings.Contains(help, "--profile") {
+		t.Error("Expected: no --profile in the subrouter help Found:", help)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected: a panic for the profile of a subrouter")
+		}
+	}()
+	sr.Profile("dev")
+}
+
+func TestResponseFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	args := write("args.txt", "--name 'a b'\n# a comment\n-I /x @sub/more.txt # the rest\n@@at\n")
+	write("sub/more.txt", "-I\n\"/y \\\"z\\\"\"\nx\\ y\n")
+	loop := write("loop.txt", "-v @loop2.txt\n")
+	write("loop2.txt", "@loop.txt\n")
+	quote := write("quote.txt", "-n\n'open\n")
+
+	tests := []struct {
+		test []string
+		args []string
+		line int
+	}{
+		{[]string{"run", "@" + args, "@@x", "@"}, []string{"run", "--name", "a b", "-I", "/x", "-I", `/y "z"`, "x y", "@at", "@x", "@"}, 0},
+		{[]string{"@" + loop}, nil, 0},
+		{[]string{"@" + quote}, nil, 2},
+		{[]string{"@" + filepath.Join(dir, "none.txt")}, nil, 0},
+	}
+
+	for _, tst := range tests {
+		out, err := expandArgs(tst.test)
+		var ferr *FileError
+		if tst.args == nil {
+			if !errors.As(err, &ferr) || ferr.Line != tst.line {
+				t.Error("Args:", tst.test, "Expected: *FileError Found:", err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(out, tst.args) {
+			t.Error("Args:", tst.test, "Expected:", tst.args, "Found:", out, err)
+		}
+	}
+
+	var opt TestConfigOptions
+	var rest []string
+	r := new(Router)
+	r.Mode(ParseResponseFiles | ParseFailOnUnhandled)
+	r.Options(&opt)
+	r.HandleE("run", func(c *Context) error {
+		rest = c.Args()
+		return nil
+	})
+	r.Arity("run", 0, 2)
+	if err := Run([]string{"run", "@" + args}, r); err != nil {
+		t.Fatal("Expected: nil Found:", err)
+	}
+	if *opt.Name != "a b" || !reflect.DeepEqual(opt.Include, []string{"/x", `/y "z"`}) || !reflect.DeepEqual(rest, []string{"x y", "@at"}) {
+		t.Error("Expected: the options from the file Found:", opt, rest)
+	}
+
+	if err := Run([]string{"run", "@" + loop}, r); err == nil || !strings.Contains(err.Error(), "includes itself") {
+		t.Error("Expected: a *FileError for the loop Found:", err)
+	}
+
+	r.Mode(ParseFailOnUnhandled)
+	if err := Run([]string{"run", "@" + args}, r); err != nil || !reflect.DeepEqual(rest, []string{"@" + args}) {
+		t.Error("Expected: the argument as is Found:", rest, err)
+	}
+}
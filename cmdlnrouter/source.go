@@ -0,0 +1,110 @@
+package cmdlnrouter
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+)
+
+// Where the value of an option is from.
+const (
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceDefault = "default"
+)
+
+// Source is where the value of a field of the options struct is from.
+type Source struct {
+	Field string // The name of the field.
+	From  string // SourceFlag, SourceEnv, SourceFile or SourceDefault, or "" when the field is not set.
+	Name  string // The option, like --timeout, the variable, like $APP_TIMEOUT, or the path:line of the file.
+	Value string // The value as given, with repeated values joined by spaces.
+
+	optShort, optLong string
+}
+
+// String returns where the value is from, like "flag --timeout", or
+// "unset" when the field is not set.
+func (s Source) String() string {
+	switch {
+	case s.From == "":
+		return "unset"
+	case s.Name == "":
+		return s.From
+	}
+	return s.From + " " + s.Name
+}
+
+// is reports if the name is the field name, in any case, or one of the
+// options, with or without the dashes.
+func (s Source) is(name string) bool {
+	if strings.EqualFold(s.Field, name) {
+		return true
+	}
+	name = strings.TrimLeft(name, "-")
+	for _, opt := range []string{s.optShort, s.optLong} {
+		if opt != "" && strings.TrimLeft(opt, "-") == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Source returns where the value of the option of the options struct is
+// from. The option is the field name, or the short or long option with
+// or without the dashes, as in Source("timeout").
+func (c *Context) Source(option string) Source {
+	for _, s := range c.sources {
+		if s.is(option) {
+			return s
+		}
+	}
+	return Source{}
+}
+
+// Sources returns where the value of each option of the options struct
+// is from, in the order of the fields.
+func (c *Context) Sources() []Source {
+	return append([]Source(nil), c.sources...)
+}
+
+// writeSources writes a table of the options, their values and where
+// they are from.
+func writeSources(w io.Writer, opts interface{}, srcs []Source) {
+	elm, err := structOf(opts)
+	if err != nil {
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 3, ' ', 0)
+	fmt.Fprintln(tw, "OPTION\tVALUE\tSOURCE")
+	for _, s := range srcs {
+		opt := s.optLong
+		if opt == "" {
+			opt = s.optShort
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", opt, display(elm.FieldByName(s.Field)), s)
+	}
+	tw.Flush()
+}
+
+// display returns the value of the field, following pointers, or ""
+// when it is nil.
+func display(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch {
+	case !v.IsValid():
+		return ""
+	case (v.Kind() == reflect.Map || v.Kind() == reflect.Slice) && v.IsNil():
+		return ""
+	}
+	return fmt.Sprint(v.Interface())
+}
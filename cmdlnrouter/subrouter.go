@@ -1,5 +1,10 @@
 package cmdlnrouter
 
+import (
+	"sort"
+	"strings"
+)
+
 type SubRouter struct {
 	subcmd string
 	*Router
@@ -20,3 +25,80 @@ func (sr *SubRouter) Handler(cmdln string, handler Handler) {
 func (sr *SubRouter) HandlerFunc(cmdln string, handler HandlerFunc) {
 	sr.Router.Handler(sr.subcmd+" "+cmdln, handler)
 }
+
+func (sr *SubRouter) HandleE(cmdln string, handle HandleE) {
+	sr.Router.HandleE(sr.subcmd+" "+cmdln, handle)
+}
+
+func (sr *SubRouter) HandlerE(cmdln string, handler HandlerE) {
+	sr.Router.HandlerE(sr.subcmd+" "+cmdln, handler)
+}
+
+func (sr *SubRouter) HandlerFuncE(cmdln string, handler HandlerFuncE) {
+	sr.Router.HandlerE(sr.subcmd+" "+cmdln, handler)
+}
+
+func (sr *SubRouter) Arity(cmdln string, min, max int) {
+	sr.Router.Arity(sr.subcmd+" "+cmdln, min, max)
+}
+
+// walk calls fn with the router and then each of its subrouters, in
+// the order of their subcommands.
+func (r *Router) walk(fn func(*Router)) {
+	fn(r)
+
+	subcmds := make([]string, 0, len(r.subs))
+	for s := range r.subs {
+		subcmds = append(subcmds, s)
+	}
+	sort.Strings(subcmds)
+
+	for _, s := range subcmds {
+		r.subs[s].Router.walk(fn)
+	}
+}
+
+// find returns the deepest subrouter whose subcommand the arguments
+// start with, or the router itself if there is none.
+func (r *Router) find(args []Argument) *Router {
+	var found *Router
+	var foundLen int
+
+	for s, sub := range r.subs {
+		flds := strings.Fields(s)
+		if len(flds) <= foundLen || len(flds) > len(args) {
+			continue
+		}
+		if hasPrefix(args, flds) {
+			found, foundLen = sub.Router, len(flds)
+		}
+	}
+
+	if found == nil {
+		return r
+	}
+	return found.find(args)
+}
+
+func hasPrefix(args []Argument, flds []string) bool {
+	for i, fld := range flds {
+		if args[i].arg != fld {
+			return false
+		}
+	}
+	return true
+}
+
+// up returns the closest router, going from r to the top router, that
+// fn returns true for.
+func (r *Router) up(top *Router, fn func(*Router) bool) *Router {
+	for x := r; x != nil; x = x.parent {
+		if fn(x) {
+			return x
+		}
+		if x == top {
+			break
+		}
+	}
+	return nil
+}
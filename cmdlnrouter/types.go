@@ -0,0 +1,177 @@
+package cmdlnrouter
+
+import (
+	"encoding"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	fileModeType        = reflect.TypeOf(os.FileMode(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	flagValueType       = reflect.TypeOf((*flag.Value)(nil)).Elem()
+)
+
+var types = struct {
+	sync.RWMutex
+	m map[reflect.Type]func(string) (interface{}, error)
+}{m: make(map[reflect.Type]func(string) (interface{}, error))}
+
+// RegisterType registers the function that converts an option value to
+// the type t, for types that do not implement encoding.TextUnmarshaler
+// or flag.Value. The function returns a value of type t, or one that can
+// be converted to it. Registering a type again replaces the function.
+func RegisterType(t reflect.Type, fn func(string) (interface{}, error)) {
+	types.Lock()
+	defer types.Unlock()
+	types.m[t] = fn
+}
+
+func registered(t reflect.Type) (fn func(string) (interface{}, error), ok bool) {
+	types.RLock()
+	defer types.RUnlock()
+	fn, ok = types.m[t]
+	return
+}
+
+// rich reports if t is one of the types convert knows, that would
+// otherwise be taken by kind or as a TextUnmarshaler.
+func rich(t reflect.Type) bool {
+	return t == timeType || t == durationType || t == fileModeType
+}
+
+// custom reports if values of type t are converted by a registered
+// function, an UnmarshalText or a Set method, rather than by kind.
+func custom(t reflect.Type) bool {
+	if _, ok := registered(t); ok {
+		return true
+	}
+	if rich(t) {
+		return false
+	}
+	if pt := reflect.PtrTo(t); pt.Implements(textUnmarshalerType) || pt.Implements(flagValueType) {
+		return true
+	}
+	return t.Kind() == reflect.Ptr && custom(t.Elem())
+}
+
+// convertCustom converts the string to a value of the custom type t.
+func convertCustom(t reflect.Type, s string) (reflect.Value, error) {
+	if fn, ok := registered(t); ok {
+		x, err := fn(s)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v := reflect.ValueOf(x)
+		switch {
+		case !v.IsValid():
+			return reflect.Zero(t), nil
+		case v.Type().AssignableTo(t):
+			return v, nil
+		case v.Type().ConvertibleTo(t):
+			return v.Convert(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("the registered function returned a %s", v.Type())
+	}
+
+	p := reflect.New(t)
+	switch x := p.Interface().(type) {
+	case encoding.TextUnmarshaler:
+		if err := x.UnmarshalText([]byte(s)); err != nil {
+			return reflect.Value{}, err
+		}
+		return p.Elem(), nil
+	case flag.Value:
+		if err := x.Set(s); err != nil {
+			return reflect.Value{}, err
+		}
+		return p.Elem(), nil
+	}
+
+	// A pointer to a custom type.
+	x, err := convertCustom(t.Elem(), s)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	p.Elem().Set(reflect.New(t.Elem()))
+	p.Elem().Elem().Set(x)
+	return p.Elem(), nil
+}
+
+// ByteSize is an options struct field type for a number of bytes, with
+// an optional unit, as in 512MiB or 10GB. K, M, G, T and P are powers of
+// 1000, and KiB, MiB, GiB, TiB and PiB powers of 1024. The unit is not
+// case sensitive and may have a B, so 10gb and 10G are 10GB.
+type ByteSize int64
+
+var byteUnits = []struct {
+	name string
+	size int64
+}{
+	{"PiB", 1 << 50}, {"PB", 1e15},
+	{"TiB", 1 << 40}, {"TB", 1e12},
+	{"GiB", 1 << 30}, {"GB", 1e9},
+	{"MiB", 1 << 20}, {"MB", 1e6},
+	{"KiB", 1 << 10}, {"KB", 1e3},
+	{"B", 1},
+}
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	i := strings.IndexFunc(s, unicode.IsLetter)
+	if i < 0 {
+		i = len(s)
+	}
+	num, unit := strings.TrimSpace(s[:i]), strings.ToLower(s[i:])
+
+	size := int64(1)
+	if unit != "" {
+		size = -1
+		for _, u := range byteUnits {
+			if name := strings.ToLower(u.name); unit == name || unit+"b" == name {
+				size = u.size
+				break
+			}
+		}
+	}
+	if size < 0 {
+		return fmt.Errorf("unknown size unit %q", s[i:])
+	}
+
+	if n, err := strconv.ParseInt(num, 10, 64); err == nil {
+		if n < 0 || n > math.MaxInt64/size {
+			return fmt.Errorf("size %q: %w", s, strconv.ErrRange)
+		}
+		*b = ByteSize(n * size)
+		return nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return fmt.Errorf("size %q: %w", s, strconv.ErrSyntax)
+	}
+	if f < 0 || f*float64(size) >= math.MaxInt64 {
+		return fmt.Errorf("size %q: %w", s, strconv.ErrRange)
+	}
+	*b = ByteSize(f * float64(size))
+	return nil
+}
+
+// String returns the size in the largest unit it is a whole number of.
+func (b ByteSize) String() string {
+	for _, u := range byteUnits {
+		if b != 0 && int64(b)%u.size == 0 {
+			return fmt.Sprintf("%d%s", int64(b)/u.size, u.name)
+		}
+	}
+	return fmt.Sprintf("%dB", int64(b))
+}
@@ -0,0 +1,191 @@
+package cmdlnrouter
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// missing returns a MissingError when the required options of the
+// routers from the owner up to r are not set, or the route was given a
+// number of arguments after its fields that is out of its arity.
+func (r *Router) missing(c *Context, owner *Router, rt *route) error {
+	m := new(MissingError)
+	for x := owner; x != nil; x = x.parent {
+		if x.opts != nil {
+			m.Options = append(m.Options, required(x.opts)...)
+		}
+		if x == r {
+			break
+		}
+	}
+
+	if n := len(c.rest); rt.rest && (n < rt.min || rt.max >= 0 && n > rt.max) {
+		switch {
+		case rt.min == rt.max:
+			m.Arguments = fmt.Sprintf("takes %d arguments, got %d", rt.min, n)
+		case rt.max < 0:
+			m.Arguments = fmt.Sprintf("takes at least %d arguments, got %d", rt.min, n)
+		default:
+			m.Arguments = fmt.Sprintf("takes %d to %d arguments, got %d", rt.min, rt.max, n)
+		}
+	}
+
+	if len(m.Options) == 0 && m.Arguments == "" {
+		return nil
+	}
+	m.Usage = r.usage()
+	return m
+}
+
+// required returns the names of the options with a required tag that
+// are not set, by the commandline or otherwise, like by a default. An
+// atleastone group with none of its options set is returned as one
+// name, like "--src or --url".
+func required(optsIn interface{}) (names []string) {
+	elm, err := structOf(optsIn)
+	if err != nil {
+		return nil
+	}
+
+	set := make(map[string]bool)
+	for i := 0; i < elm.NumField(); i++ {
+		tField := elm.Type().Field(i)
+		tags := tField.Tag.Get("cmdln")
+		if tags == "" {
+			continue
+		}
+		optShort, optLong, _ := parseCmdlnTag(tags)
+		name := optName(optShort, optLong)
+		set[name] = !elm.Field(i).IsZero()
+		if tField.Tag.Get("required") == "true" && !set[name] {
+			names = append(names, name)
+		}
+	}
+
+	rules, _ := groupRules(elm)
+	for _, g := range rules {
+		if g.rule != "atleastone" {
+			continue
+		}
+		var found bool
+		for _, opt := range g.opts {
+			found = found || set[opt]
+		}
+		if !found {
+			names = append(names, strings.Join(g.opts, " or "))
+		}
+	}
+	return names
+}
+
+// usage returns the usage line from Help.
+func (r *Router) usage() string {
+	return strings.SplitN(r.Help(), "\n", 2)[0]
+}
+
+// checkValue validates the value of the option of the field, returning
+// a broken rule as a ValidationError for the option, and a rule that can
+// not be used as a ConfigError.
+func checkValue(elm reflect.Value, tField reflect.StructField, opt, val string) error {
+	err := validate(tField.Type, tField.Tag, val)
+	if err == nil {
+		return nil
+	}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		verr.Option = opt
+		return verr
+	}
+	return &ConfigError{Type: elm.Type(), Field: tField.Name, Err: err}
+}
+
+// validate checks the value of an option against the rules in the tags
+// of its field of type t: choices, min, max and pattern. Each part of a
+// value split on the cmdsep, and the value of a key=value pair, is
+// checked on its own. A broken rule is returned as a ValidationError,
+// and a rule that can not be used as any other error.
+func validate(t reflect.Type, tag reflect.StructTag, val string) error {
+	vals := []string{val}
+	if repeatable(t) {
+		vals = split(val, tag.Get("cmdsep"))
+		if t.Kind() == reflect.Map {
+			for i, kv := range vals {
+				_, vals[i], _ = strings.Cut(kv, "=")
+			}
+		}
+		t = t.Elem()
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	for _, v := range vals {
+		if c, ok := tag.Lookup("choices"); ok && !contains(strings.Split(c, ","), v) {
+			return &ValidationError{Value: v, Rule: "choices", Limit: c}
+		}
+		for _, rule := range []string{"min", "max"} {
+			limit, ok := tag.Lookup(rule)
+			if !ok {
+				continue
+			}
+			n, err := compare(t, tag, v, limit)
+			if err != nil {
+				return fmt.Errorf("the %s %q: %w", rule, limit, err)
+			}
+			if rule == "min" && n < 0 || rule == "max" && n > 0 {
+				return &ValidationError{Value: v, Rule: rule, Limit: limit}
+			}
+		}
+		if p, ok := tag.Lookup("pattern"); ok {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("the pattern %q: %w", p, err)
+			}
+			if !re.MatchString(v) {
+				return &ValidationError{Value: v, Rule: "pattern", Limit: p}
+			}
+		}
+	}
+	return nil
+}
+
+// compare converts a and b to type t and returns -1, 0 or 1 as a is
+// less than, equal to or more than b.
+func compare(t reflect.Type, tag reflect.StructTag, a, b string) (int, error) {
+	x, err := convert(t, a, tag)
+	if err != nil {
+		return 0, err
+	}
+	y, err := convert(t, b, tag)
+	if err != nil {
+		return 0, err
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp(x.Int() < y.Int(), x.Int() > y.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cmp(x.Uint() < y.Uint(), x.Uint() > y.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return cmp(x.Float() < y.Float(), x.Float() > y.Float()), nil
+	}
+	if t == timeType {
+		tx, ty := x.Interface().(time.Time), y.Interface().(time.Time)
+		return cmp(tx.Before(ty), tx.After(ty)), nil
+	}
+	return 0, fmt.Errorf("the type %s can not be compared", t)
+}
+
+func cmp(less, more bool) int {
+	switch {
+	case less:
+		return -1
+	case more:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,268 @@
+package cmdlnrouter
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Count is an options struct field type that counts the occurrences of
+// its option, so -vvv or -v -v -v is 3. A *int field with a cmdcount
+// tag counts the same way, and is nil when the option is not given.
+type Count int
+
+var countType = reflect.TypeOf(Count(0))
+
+type byKey []M
+
+func (s byKey) Len() int           { return len(s) }
+func (s byKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byKey) Less(i, j int) bool { return s[i].k < s[j].k }
+
+// occurrences returns every occurrence of the option names, in the
+// order they are on the commandline.
+func occurrences(argMap map[string][]M, names ...string) (argvs []M) {
+	for _, name := range names {
+		if name != "" {
+			argvs = append(argvs, argMap[name]...)
+		}
+	}
+	sort.Stable(byKey(argvs))
+	return argvs
+}
+
+// counter reports if the field counts the occurrences of its option.
+func counter(f reflect.StructField) bool {
+	switch f.Type {
+	case countType, reflect.PtrTo(countType):
+		return true
+	case reflect.TypeOf(new(int)):
+		return f.Tag.Get("cmdcount") != ""
+	}
+	return false
+}
+
+// setCount sets a counter field to n.
+func setCount(vField reflect.Value, n int) {
+	if vField.Kind() != reflect.Ptr {
+		vField.SetInt(int64(n))
+		return
+	}
+	p := reflect.New(vField.Type().Elem())
+	p.Elem().SetInt(int64(n))
+	vField.Set(p)
+}
+
+// negated returns the --no-<long> form of a long flag.
+func negated(long string) string {
+	return "--no-" + strings.TrimLeft(long, "-")
+}
+
+// boolValue returns the value of a flag occurrence. It is true, or false
+// for the --no-<long> form, unless a value is given, as in --color=false.
+func boolValue(argv M) (bool, error) {
+	b := !strings.HasPrefix(argv.opt, "--no-")
+	if argv.v != -2 {
+		return b, nil
+	}
+	x, err := strconv.ParseBool(argv.val)
+	if err != nil {
+		return false, err
+	}
+	return x == b, nil
+}
+
+// supported reports if an options struct field of type t can be set.
+func supported(t reflect.Type) bool {
+	if custom(t) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Int:
+		return t == countType
+	case reflect.Ptr:
+		return t == reflect.TypeOf(new(bool)) || convertible(t.Elem())
+	case reflect.Slice:
+		return convertible(t.Elem())
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && convertible(t.Elem())
+	}
+	return false
+}
+
+// repeatable reports if an options struct field of type t collects
+// every occurrence of its option.
+func repeatable(t reflect.Type) bool {
+	return !custom(t) && (t.Kind() == reflect.Slice || t.Kind() == reflect.Map)
+}
+
+// convertible reports if a single value can be converted to type t.
+func convertible(t reflect.Type) bool {
+	if custom(t) || rich(t) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String:
+		return true
+	}
+	return false
+}
+
+// setValue sets a field of a custom type or a pointer field to the
+// value, appends the value to a slice field, or adds the key=value pair
+// to a map field. For a slice or a map with a sep the value is split on
+// it first, so -I a,b is the same as -I a -I b. Nothing is set when a
+// value can not be converted. The tag holds the cmdsep and cmdlayout.
+func setValue(vField reflect.Value, val string, tag reflect.StructTag) error {
+	t, sep := vField.Type(), tag.Get("cmdsep")
+	if custom(t) {
+		x, err := convertCustom(t, val)
+		if err != nil {
+			return err
+		}
+		vField.Set(x)
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		x, err := convert(t.Elem(), val, tag)
+		if err != nil {
+			return err
+		}
+		p := reflect.New(t.Elem())
+		p.Elem().Set(x)
+		vField.Set(p)
+	case reflect.Slice:
+		vals := split(val, sep)
+		xs := make([]reflect.Value, 0, len(vals))
+		for _, v := range vals {
+			x, err := convert(t.Elem(), v, tag)
+			if err != nil {
+				return err
+			}
+			xs = append(xs, x)
+		}
+		vField.Set(reflect.Append(vField, xs...))
+	case reflect.Map:
+		vals := split(val, sep)
+		ks, xs := make([]reflect.Value, 0, len(vals)), make([]reflect.Value, 0, len(vals))
+		for _, kv := range vals {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				return fmt.Errorf("%q is not a key=value pair", kv)
+			}
+			x, err := convert(t.Elem(), v, tag)
+			if err != nil {
+				return err
+			}
+			key := reflect.ValueOf(k).Convert(t.Key())
+			if vField.MapIndex(key).IsValid() {
+				return fmt.Errorf("duplicate key %q", k)
+			}
+			for _, seen := range ks {
+				if seen.String() == k {
+					return fmt.Errorf("duplicate key %q", k)
+				}
+			}
+			ks, xs = append(ks, key), append(xs, x)
+		}
+		if vField.IsNil() {
+			vField.Set(reflect.MakeMap(t))
+		}
+		for i := range ks {
+			vField.SetMapIndex(ks[i], xs[i])
+		}
+	}
+	return nil
+}
+
+// setFrom sets the field from values that are not on the commandline,
+// like the default tag. A repeatable field is replaced with all of the
+// values, rather than added to, and any other field is set to the last.
+func setFrom(vField reflect.Value, tField reflect.StructField, vals ...string) error {
+	val := vals[len(vals)-1]
+	switch {
+	case counter(tField):
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		setCount(vField, n)
+	case vField.Type() == reflect.TypeOf(new(bool)):
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		vField.Set(reflect.ValueOf(&b))
+	case repeatable(vField.Type()):
+		x := reflect.New(vField.Type()).Elem()
+		for _, val := range vals {
+			if err := setValue(x, val, tField.Tag); err != nil {
+				return err
+			}
+		}
+		vField.Set(x)
+	default:
+		return setValue(vField, val, tField.Tag)
+	}
+	return nil
+}
+
+// split splits the value on sep, when there is one.
+func split(val, sep string) []string {
+	if sep == "" {
+		return []string{val}
+	}
+	return strings.Split(val, sep)
+}
+
+// convert converts the string to a value of type t. Integers are
+// decimal, a file mode is octal and a time.Time is in the cmdlayout of
+// the tag, or RFC 3339.
+func convert(t reflect.Type, s string, tag reflect.StructTag) (reflect.Value, error) {
+	if custom(t) {
+		return convertCustom(t, s)
+	}
+
+	var x interface{}
+	var err error
+	switch t {
+	case timeType:
+		x, err = time.Parse(layout(tag), s)
+	case durationType:
+		x, err = time.ParseDuration(s)
+	case fileModeType:
+		var n uint64
+		n, err = strconv.ParseUint(s, 8, 32)
+		x = os.FileMode(n)
+	default:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			x, err = strconv.ParseInt(s, 10, t.Bits())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			x, err = strconv.ParseUint(s, 10, t.Bits())
+		case reflect.Float32, reflect.Float64:
+			x, err = strconv.ParseFloat(s, t.Bits())
+		default:
+			x = s
+		}
+	}
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.ValueOf(x).Convert(t), nil
+}
+
+// layout returns the time layout of the tag.
+func layout(tag reflect.StructTag) string {
+	if l := tag.Get("cmdlayout"); l != "" {
+		return l
+	}
+	return time.RFC3339
+}
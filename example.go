@@ -42,11 +42,11 @@ func main() {
 	sub.Handle("kicks", doExample)
 
 	r.Handle("example", doExample)
-	r.HandlerFunc("example :func", cmdln.CmdlnHandlerFunc(func(c *cmdln.Context) {
+	r.HandlerFunc("example :func", cmdln.HandlerFunc(func(c *cmdln.Context) {
 		fmt.Println(Version())
-		os.Exit(0)
+		c.Exit(0)
 	}))
-	r.HandlerFunc("example ask something", cmdln.CmdlnHandlerFunc(func(c *cmdln.Context) {
+	r.HandlerFunc("example ask something", cmdln.HandlerFunc(func(c *cmdln.Context) {
 		resp := c.Ask("What is your name?")
 		fmt.Println("<<<", resp)
 	}))